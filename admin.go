@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+// maxSignedBodyBytes caps the body read to verify a signed request
+const maxSignedBodyBytes = 1 << 20 // 1MB
+
+// authorizeAdmin checks that the request carries the configured admin API
+// key, or is signed by a configured integrator. Key auth is disabled
+// entirely when no key is configured.
+func (cfg *apiConfig) authorizeAdmin(r *http.Request) error {
+	if auth.IsSignedRequest(r.Header) {
+		return cfg.verifySignedRequest(r)
+	}
+	if cfg.adminAPIKey == "" {
+		return errors.New("admin API is disabled")
+	}
+	key, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.adminAPIKey)) != 1 {
+		return errors.New("invalid admin API key")
+	}
+	return nil
+}
+
+// verifySignedRequest checks an HMAC-signed request from a machine caller.
+// The body is read to hash it and put back for the handler.
+func (cfg *apiConfig) verifySignedRequest(r *http.Request) error {
+	if len(cfg.signingSecrets) == 0 {
+		return errors.New("request signing is disabled")
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxSignedBodyBytes {
+		return errors.New("signed request body is too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	keyID, err := auth.VerifySignature(r.Header, r.Method, r.URL.RequestURI(), body, cfg.signingSecrets, cfg.signatureMaxSkew)
+	if err != nil {
+		return err
+	}
+	log.Printf("Signed request from %s: %s %s", keyID, r.Method, r.URL.Path)
+	return nil
+}
+
+// parseSigningSecrets reads "keyID:secret,..." into a map.
+func parseSigningSecrets(raw string) (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		keyID, secret, ok := strings.Cut(entry, ":")
+		if !ok || keyID == "" || secret == "" {
+			return nil, fmt.Errorf("invalid entry %q, want keyID:secret", entry)
+		}
+		secrets[keyID] = secret
+	}
+	return secrets, nil
+}
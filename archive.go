@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+// archiveEntry is one file in a video's zip download.
+type archiveEntry struct {
+	name string
+	open func(ctx context.Context) (io.ReadCloser, error)
+}
+
+func s3Entry(name string, target *storageTarget, key string) archiveEntry {
+	return archiveEntry{name: name, open: func(ctx context.Context) (io.ReadCloser, error) {
+		out, err := target.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: &target.bucket,
+			Key:    &key,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Body, nil
+	}}
+}
+
+// assetEntry resolves a stored asset URL, in S3 or the local assets
+// directory, to an archive entry named base plus the asset's extension.
+func (cfg *apiConfig) assetEntry(base, rawURL string) (archiveEntry, bool) {
+	if target, key, ok := cfg.locateObject(rawURL); ok {
+		return s3Entry(base+path.Ext(key), target, key), true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/assets/") {
+		return archiveEntry{}, false
+	}
+	name := path.Base(u.Path)
+	return archiveEntry{name: base + path.Ext(name), open: func(context.Context) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(cfg.assetsRoot, name))
+	}}, true
+}
+
+// Download a zip of everything stored for a video: the video file,
+// thumbnail, preview, chapters track, DASH package and its record. The zip
+// is streamed as it's built, one object at a time. Admins may fetch any
+// video.
+func (cfg *apiConfig) handlerVideoArchive(w http.ResponseWriter, r *http.Request) {
+	isAdmin := cfg.authorizeAdmin(r) == nil
+	var userID uuid.UUID
+	if !isAdmin {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if !isAdmin && video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+		return
+	}
+
+	var entries []archiveEntry
+	for _, asset := range []struct {
+		name string
+		url  *string
+	}{
+		{"video", video.VideoURL},
+		{"thumbnail", video.ThumbnailURL},
+		{"preview", video.PreviewURL},
+		{"chapters", video.ChaptersURL},
+	} {
+		if asset.url == nil {
+			continue
+		}
+		if entry, ok := cfg.assetEntry(asset.name, *asset.url); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if video.DashURL != nil {
+		if target, key, ok := cfg.locateObject(*video.DashURL); ok {
+			prefix := path.Dir(key) + "/"
+			keys, err := target.listObjects(r.Context(), prefix)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't list DASH segments", err)
+				return
+			}
+			for _, k := range keys {
+				entries = append(entries, s3Entry("dash/"+strings.TrimPrefix(k, prefix), target, k))
+			}
+		}
+	}
+
+	record, err := json.MarshalIndent(cfg.prepareVideo(video), "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode video", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, video.ID))
+	w.WriteHeader(http.StatusOK)
+
+	// Past this point the status is sent, so failures can only cut the zip short
+	zw := zip.NewWriter(w)
+	if err := writeArchiveFile(zw, "video.json", zip.Deflate, strings.NewReader(string(record))); err != nil {
+		log.Printf("Couldn't write archive for video %s: %v", video.ID, err)
+		return
+	}
+	for _, entry := range entries {
+		body, err := entry.open(r.Context())
+		if err != nil {
+			log.Printf("Couldn't open %s for archive of video %s: %v", entry.name, video.ID, err)
+			return
+		}
+		// Media is already compressed, so store it as is
+		err = writeArchiveFile(zw, entry.name, zip.Store, body)
+		body.Close()
+		if err != nil {
+			log.Printf("Couldn't write %s to archive of video %s: %v", entry.name, video.ID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Couldn't finish archive for video %s: %v", video.ID, err)
+	}
+}
+
+func writeArchiveFile(zw *zip.Writer, name string, method uint16, body io.Reader) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, body)
+	return err
+}
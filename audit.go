@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	auditActionCreate           = "create"
+	auditActionUpdate           = "update"
+	auditActionDelete           = "delete"
+	auditActionUpload           = "upload"
+	auditActionUploadThumbnail  = "upload_thumbnail"
+	auditActionShare            = "share"
+	auditActionUnshare          = "unshare"
+	auditActionShareLink        = "share_link"
+	auditActionRevokeShareLink  = "revoke_share_link"
+	auditActionAddAudioTrack    = "add_audio_track"
+	auditActionRemoveAudioTrack = "remove_audio_track"
+)
+
+const auditQueueSize = 1024
+
+// auditLogger writes audit events from a background goroutine so recording
+// them never slows down the request that triggered them.
+type auditLogger struct {
+	db     database.Client
+	events chan database.CreateAuditEventParams
+}
+
+func newAuditLogger(db database.Client) *auditLogger {
+	a := &auditLogger{
+		db:     db,
+		events: make(chan database.CreateAuditEventParams, auditQueueSize),
+	}
+	go a.run()
+	return a
+}
+
+func (a *auditLogger) run() {
+	for event := range a.events {
+		if err := a.db.CreateAuditEvent(event); err != nil {
+			log.Printf("Couldn't write audit event %s on video %s: %v", event.Action, event.VideoID, err)
+		}
+	}
+}
+
+// record queues an event. A nil actor means the system acted on its own,
+// e.g. the failed-upload reaper. Events are dropped (and logged) rather
+// than blocking if the queue is full.
+func (a *auditLogger) record(actorID *uuid.UUID, videoID uuid.UUID, action string) {
+	event := database.CreateAuditEventParams{
+		ActorID: actorID,
+		VideoID: videoID,
+		Action:  action,
+	}
+	select {
+	case a.events <- event:
+	default:
+		log.Printf("Audit queue full, dropping %s event on video %s", action, videoID)
+	}
+}
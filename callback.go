@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	callbackAttempts = 3
+	callbackTimeout  = 10 * time.Second
+)
+
+// uploadCallback is POSTed to an upload's callback_url once processing ends.
+type uploadCallback struct {
+	VideoID         uuid.UUID            `json:"video_id"`
+	Status          database.VideoStatus `json:"status"`
+	VideoURL        *string              `json:"video_url"`
+	ProcessingError *string              `json:"processing_error,omitempty"`
+}
+
+// checkCallbackURL refuses callback URLs that aren't https or that name a
+// non-public address. Hostnames are checked again when dialled.
+func checkCallbackURL(u *url.URL) error {
+	if u.Scheme != "https" {
+		return errors.New("must be an https URL")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("has no host")
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("can't point at %s", host)
+	}
+	return nil
+}
+
+// callbackClient only connects to public addresses and doesn't follow
+// redirects, which could otherwise lead somewhere checkCallbackURL refused.
+func callbackClient() *http.Client {
+	return &http.Client{
+		Timeout: callbackTimeout,
+		Transport: &http.Transport{
+			// No proxy: the dial check must see the real destination
+			Proxy:               nil,
+			DialContext:         publicDialer().DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sendUploadCallback tells target how a video's processing ended, retrying
+// a few times and signing like quota webhooks when CALLBACK_SECRET is set.
+// It only logs failures: the upload itself has already succeeded or failed.
+func (cfg *apiConfig) sendUploadCallback(target *url.URL, videoID uuid.UUID) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		log.Printf("Couldn't load video %s for its callback: %v", videoID, err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		return
+	}
+	body, err := json.Marshal(uploadCallback{
+		VideoID:         video.ID,
+		Status:          video.Status,
+		VideoURL:        video.VideoURL,
+		ProcessingError: video.ProcessingError,
+	})
+	if err != nil {
+		log.Printf("Couldn't encode callback for video %s: %v", videoID, err)
+		return
+	}
+
+	client := callbackClient()
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(client, target, body, cfg.callbackSecret)
+		if err == nil {
+			return
+		}
+		if attempt == callbackAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 4
+	}
+	log.Printf("Couldn't deliver callback for video %s to %s after %d attempts: %v", videoID, target.Host, callbackAttempts, err)
+}
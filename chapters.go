@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const maxChapters = 500
+
+// validateChapters checks chapters are titled, start in ascending order and
+// fall within the video. A duration of 0 means it isn't known yet.
+func validateChapters(chapters []database.Chapter, duration float64) error {
+	if len(chapters) > maxChapters {
+		return fmt.Errorf("at most %d chapters are allowed", maxChapters)
+	}
+	for i, chapter := range chapters {
+		if strings.TrimSpace(chapter.Title) == "" {
+			return fmt.Errorf("chapter %d needs a title", i+1)
+		}
+		if chapter.Start < 0 {
+			return fmt.Errorf("chapter %d starts before the video", i+1)
+		}
+		if i > 0 && chapter.Start <= chapters[i-1].Start {
+			return fmt.Errorf("chapter %d must start after chapter %d", i+1, i)
+		}
+		if duration > 0 && chapter.Start >= duration {
+			return fmt.Errorf("chapter %d starts after the video ends", i+1)
+		}
+	}
+	return nil
+}
+
+// formatVTTTimestamp renders seconds as a WebVTT HH:MM:SS.mmm timestamp.
+func formatVTTTimestamp(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// chaptersVTT renders chapters as a WebVTT chapters track. Each chapter runs
+// until the next one starts, and the last until the end of the video.
+func chaptersVTT(chapters []database.Chapter, duration float64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n")
+	for i, chapter := range chapters {
+		end := duration
+		if i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		fmt.Fprintf(&buf, "\n%d\n%s --> %s\n%s\n", i+1,
+			formatVTTTimestamp(chapter.Start), formatVTTTimestamp(end),
+			strings.ReplaceAll(chapter.Title, "\n", " "))
+	}
+	return buf.Bytes()
+}
+
+// uploadChaptersVTT stores a video's chapters as a WebVTT track next to its
+// file and returns the track's URL.
+func (cfg *apiConfig) uploadChaptersVTT(ctx context.Context, video database.Video, chapters []database.Chapter) (string, error) {
+	if video.VideoURL == nil {
+		return "", errors.New("video has no uploaded file yet")
+	}
+	target, _, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		return "", errors.New("video file isn't stored in our bucket")
+	}
+	name, err := randomObjectName()
+	if err != nil {
+		return "", err
+	}
+	key := "chapters/" + name + ".vtt"
+	if err := target.putObject(ctx, key, bytes.NewReader(chaptersVTT(chapters, video.DurationSeconds)), "text/vtt"); err != nil {
+		return "", err
+	}
+	return target.objectURL(key), nil
+}
+
+// Replace a video's chapters. An empty list removes them.
+func (cfg *apiConfig) handlerVideoChaptersUpdate(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var params struct {
+		Chapters []database.Chapter `json:"chapters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionEdit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+	if err := validateChapters(params.Chapters, video.DurationSeconds); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	// The track needs to know where the last chapter ends, so it's only made
+	// once the video's duration is known
+	var chaptersURL *string
+	if cfg.chaptersVTT && len(params.Chapters) > 0 && video.DurationSeconds > 0 {
+		u, err := cfg.uploadChaptersVTT(r.Context(), video, params.Chapters)
+		if err != nil {
+			log.Printf("Couldn't upload chapters track for video %s: %v", videoID, err)
+		} else {
+			chaptersURL = &u
+		}
+	}
+	if err := cfg.db.SetChapters(videoID, params.Chapters, chaptersURL); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chapters", err)
+		return
+	}
+	cfg.audit.record(&userID, videoID, auditActionUpdate)
+	cfg.recordActivity(userID, videoID, database.ActivityEdit)
+
+	// The replaced track is unreferenced now
+	if video.ChaptersURL != nil {
+		if target, key, ok := cfg.locateObject(*video.ChaptersURL); ok {
+			if err := target.deleteObject(r.Context(), key); err != nil {
+				log.Printf("Couldn't delete old chapters track for video %s: %v", videoID, err)
+			}
+		}
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	dashManifestName = "manifest.mpd"
+	// dashSegmentSeconds is the segment length; renditions put a keyframe
+	// at every boundary so players can switch between them
+	dashSegmentSeconds = 4
+)
+
+// parseDASHRenditions reads a DASH ladder such as "1080:5000k,720:2800k",
+// each entry a height (the shorter side) and a target bitrate in bits per
+// second with an optional k or M suffix. The result is tallest first.
+func parseDASHRenditions(spec string) ([]database.Rendition, error) {
+	var renditions []database.Rendition
+	seen := map[int]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		height, bitrate, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rendition %q, want height:bitrate", entry)
+		}
+		h, err := strconv.Atoi(height)
+		if err != nil || h <= 0 || h%2 != 0 {
+			return nil, fmt.Errorf("invalid height in %q, want a positive even number", entry)
+		}
+		if seen[h] {
+			return nil, fmt.Errorf("height %d is listed twice", h)
+		}
+		seen[h] = true
+		b, err := parseBitrate(bitrate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bitrate in %q: %w", entry, err)
+		}
+		renditions = append(renditions, database.Rendition{Height: h, Bitrate: b})
+	}
+	sort.Slice(renditions, func(i, j int) bool { return renditions[i].Height > renditions[j].Height })
+	return renditions, nil
+}
+
+// parseBitrate reads bits per second written as "800000", "800k" or "2.5M".
+func parseBitrate(s string) (int, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		multiplier, s = 1e3, s[:len(s)-1]
+	case strings.HasSuffix(s, "M"):
+		multiplier, s = 1e6, s[:len(s)-1]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("%q isn't a positive bitrate", s)
+	}
+	return int(f * multiplier), nil
+}
+
+// renditionsFor keeps the ladder entries a source can fill without
+// upscaling. shortSide is the source's shorter side in pixels.
+func renditionsFor(ladder []database.Rendition, shortSide int) []database.Rendition {
+	var fitting []database.Rendition
+	for _, rendition := range ladder {
+		if rendition.Height <= shortSide {
+			fitting = append(fitting, rendition)
+		}
+	}
+	return fitting
+}
+
+// processVideoToDASH packages a processed mp4 as MPEG-DASH: fragmented mp4
+// segments plus an .mpd manifest, written to a new temp directory whose
+// path is returned. With no renditions the streams are copied as they are;
+// otherwise the video is re-encoded once per rendition at its bitrate,
+// scaled on its shorter side, and the audio is shared between them.
+func processVideoToDASH(ctx context.Context, srcPath string, stall time.Duration, renditions []database.Rendition, portrait, hasAudio bool) (string, error) {
+	outDir, err := os.MkdirTemp("", "tubely-dash-*")
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"-i", srcPath}
+	if len(renditions) == 0 {
+		args = append(args, "-map", "0:v", "-map", "0:a?", "-c", "copy")
+	} else {
+		for range renditions {
+			args = append(args, "-map", "0:v:0")
+		}
+		args = append(args, "-map", "0:a?",
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", dashSegmentSeconds),
+			"-c:a", "copy",
+		)
+		for i, rendition := range renditions {
+			scale := fmt.Sprintf("scale=-2:%d", rendition.Height)
+			if portrait {
+				scale = fmt.Sprintf("scale=%d:-2", rendition.Height)
+			}
+			// Capped VBR: the bitrate is the target, with a little headroom
+			// for complex scenes
+			args = append(args,
+				fmt.Sprintf("-filter:v:%d", i), scale,
+				fmt.Sprintf("-b:v:%d", i), strconv.Itoa(rendition.Bitrate),
+				fmt.Sprintf("-maxrate:v:%d", i), strconv.Itoa(rendition.Bitrate*107/100),
+				fmt.Sprintf("-bufsize:v:%d", i), strconv.Itoa(rendition.Bitrate*2),
+			)
+		}
+		// One adaptation set for all the video renditions so players switch
+		// between them
+		sets := "id=0,streams=v"
+		if hasAudio {
+			sets += " id=1,streams=a"
+		}
+		args = append(args, "-adaptation_sets", sets)
+	}
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(dashSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		filepath.Join(outDir, dashManifestName),
+	)
+
+	err = runFFmpeg(ctx, stall, args...)
+	if err != nil {
+		os.RemoveAll(outDir)
+		return "", fmt.Errorf("ffmpeg dash failed: %w", err)
+	}
+	return outDir, nil
+}
+
+// createDASH packages a local copy of the video as DASH, uploads the
+// manifest and segments under one prefix and records the manifest URL.
+func (cfg *apiConfig) createDASH(ctx context.Context, target *storageTarget, videoID uuid.UUID, srcPath string) (string, error) {
+	var renditions []database.Rendition
+	var portrait, hasAudio bool
+	if len(cfg.dashRenditions) > 0 {
+		width, height, err := getVideoResolution(ctx, srcPath)
+		if err != nil {
+			return "", err
+		}
+		portrait = height > width
+		renditions = renditionsFor(cfg.dashRenditions, min(width, height))
+		if hasAudio, err = hasAudioStream(ctx, srcPath); err != nil {
+			return "", err
+		}
+	}
+
+	outDir, err := processVideoToDASH(ctx, srcPath, cfg.ffmpegStallTimeout, renditions, portrait, hasAudio)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(outDir)
+
+	name, err := randomObjectName()
+	if err != nil {
+		return "", err
+	}
+	prefix := "dash/" + name + "/"
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		contentType := "video/iso.segment"
+		if entry.Name() == dashManifestName {
+			contentType = "application/dash+xml"
+		}
+		if err := uploadFile(ctx, target, prefix+entry.Name(), filepath.Join(outDir, entry.Name()), contentType); err != nil {
+			return "", err
+		}
+	}
+
+	manifestURL := target.objectURL(prefix + dashManifestName)
+	if err := cfg.db.SetDashURL(videoID, &manifestURL, renditions); err != nil {
+		return "", err
+	}
+	return manifestURL, nil
+}
+
+func uploadFile(ctx context.Context, target *storageTarget, key, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return target.putObject(ctx, key, f, contentType)
+}
+
+// refreshDASH regenerates the DASH packaging after a new upload, or clears
+// the old manifest when DASH is off or packaging fails. It's best effort.
+func (cfg *apiConfig) refreshDASH(ctx context.Context, target *storageTarget, video database.Video, processedPath string) {
+	if cfg.dashEnabled {
+		_, err := cfg.createDASH(ctx, target, video.ID, processedPath)
+		if err == nil {
+			return
+		}
+		log.Printf("Couldn't package video %s as DASH: %v", video.ID, err)
+	}
+	if video.DashURL != nil {
+		if err := cfg.db.SetDashURL(video.ID, nil, nil); err != nil {
+			log.Printf("Couldn't clear stale DASH manifest for video %s: %v", video.ID, err)
+		}
+	}
+}
+
+// List the URLs of every asset stored for a video
+func (cfg *apiConfig) handlerVideoAssets(w http.ResponseWriter, r *http.Request) {
+	type audioTrack struct {
+		Language string `json:"language"`
+		Label    string `json:"label"`
+		URL      string `json:"url"`
+	}
+	type response struct {
+		VideoURL     *string      `json:"video_url,omitempty"`
+		ThumbnailURL *string      `json:"thumbnail_url,omitempty"`
+		PreviewURL   *string      `json:"preview_url,omitempty"`
+		DashURL      *string      `json:"dash_url,omitempty"`
+		AudioTracks  []audioTrack `json:"audio_tracks"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+
+	tracks, err := cfg.db.GetAudioTracks(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audio tracks", err)
+		return
+	}
+	audioTracks := make([]audioTrack, 0, len(tracks))
+	for _, track := range tracks {
+		audioTracks = append(audioTracks, audioTrack{Language: track.Language, Label: track.Label, URL: track.URL})
+	}
+
+	video = cfg.prepareVideo(video)
+	respondWithJSON(w, http.StatusOK, response{
+		VideoURL:     video.VideoURL,
+		ThumbnailURL: video.ThumbnailURL,
+		PreviewURL:   video.PreviewURL,
+		DashURL:      video.DashURL,
+		AudioTracks:  audioTracks,
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("free space isn't available on this platform")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// moovFirst reports whether an MP4's index (the moov box) comes before its
+// media data (mdat), so players can start before the whole file arrives.
+// Only the top-level box headers are read; the boxes themselves are skipped.
+func moovFirst(filePath string) (bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var header [16]byte
+	var offset int64
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:8]) {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			return false, nil
+		case 1:
+			// A 64-bit size follows the type
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, fmt.Errorf("invalid mp4 box size %d at offset %d", size, offset)
+		}
+		offset += size
+	}
+}
+
+// alreadyFastStart reports whether a file is a fast-start MP4 holding only
+// video and audio, which the remux would leave as it is.
+func alreadyFastStart(ctx context.Context, filePath string) bool {
+	if checkMP4(ctx, filePath) != nil {
+		return false
+	}
+	if ok, err := moovFirst(filePath); err != nil || !ok {
+		return false
+	}
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return false
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" && stream.CodecType != "audio" {
+			return false
+		}
+	}
+	return true
+}
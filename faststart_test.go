@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mp4Box encodes a box with a 32-bit size.
+func mp4Box(typ string, payload []byte) []byte {
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box[:4], uint32(8+len(payload)))
+	copy(box[4:8], typ)
+	return append(box, payload...)
+}
+
+// mp4LargeBox encodes a box with size 1 and the real size in 64 bits.
+func mp4LargeBox(typ string, payload []byte) []byte {
+	box := make([]byte, 16, 16+len(payload))
+	binary.BigEndian.PutUint32(box[:4], 1)
+	copy(box[4:8], typ)
+	binary.BigEndian.PutUint64(box[8:16], uint64(16+len(payload)))
+	return append(box, payload...)
+}
+
+func writeMP4(t testing.TB, boxes ...[]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, bytes.Join(boxes, nil), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMoovFirst(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	moov := mp4Box("moov", make([]byte, 64))
+	mdat := mp4Box("mdat", make([]byte, 1024))
+
+	tests := []struct {
+		name  string
+		boxes [][]byte
+		want  bool
+	}{
+		{"moov before mdat", [][]byte{ftyp, moov, mdat}, true},
+		{"mdat before moov", [][]byte{ftyp, mdat, moov}, false},
+		{"free box skipped", [][]byte{ftyp, mp4Box("free", make([]byte, 32)), moov, mdat}, true},
+		{"64-bit box skipped", [][]byte{ftyp, mp4LargeBox("free", make([]byte, 40)), moov, mdat}, true},
+		{"64-bit mdat first", [][]byte{ftyp, mp4LargeBox("mdat", make([]byte, 1024)), moov}, false},
+		{"neither box", [][]byte{ftyp}, false},
+		{"box running to the end", [][]byte{ftyp, {0, 0, 0, 0, 'f', 'r', 'e', 'e'}, moov}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := moovFirst(writeMP4(t, tt.boxes...))
+			if err != nil {
+				t.Fatalf("moovFirst: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("moovFirst = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoovFirstInvalidBoxSize(t *testing.T) {
+	path := writeMP4(t, []byte{0, 0, 0, 4, 'f', 't', 'y', 'p'})
+	if _, err := moovFirst(path); err == nil {
+		t.Error("box smaller than its header accepted")
+	}
+}
+
+// diskBytesWritten reads the bytes this process has passed to write(2),
+// or returns false where /proc/self/io doesn't exist.
+func diskBytesWritten() (int64, bool) {
+	f, err := os.Open("/proc/self/io")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "wchar: "); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// BenchmarkUploadSpool measures the bytes written to disk to receive a
+// fast-start MP4 that needs no transcode, which is then uploaded from where
+// it landed. "parse_and_copy" is the old path: ParseMultipartForm spools
+// the file, which is then copied to a second temp file.
+func BenchmarkUploadSpool(b *testing.B) {
+	const mediaBytes = 32 << 20
+	video := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		mp4Box("moov", make([]byte, 4096)),
+		mp4Box("mdat", make([]byte, mediaBytes)),
+	}, nil)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("video", "clip.mp4")
+	if err != nil {
+		b.Fatal(err)
+	}
+	part.Write(video)
+	mw.Close()
+	contentType := mw.FormDataContentType()
+
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", bytes.NewReader(body.Bytes()))
+		r.Header.Set("Content-Type", contentType)
+		return r
+	}
+
+	run := func(b *testing.B, receive func(r *http.Request) (string, error)) {
+		b.SetBytes(int64(len(video)))
+		start, measured := diskBytesWritten()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			path, err := receive(newRequest())
+			if err != nil {
+				b.Fatal(err)
+			}
+			if ok, err := moovFirst(path); err != nil || !ok {
+				b.Fatalf("moovFirst = %v, %v; want a fast-start file", ok, err)
+			}
+			os.Remove(path)
+		}
+		b.StopTimer()
+		if end, ok := diskBytesWritten(); measured && ok {
+			b.ReportMetric(float64(end-start)/float64(b.N), "disk-bytes/op")
+		}
+	}
+
+	b.Run("form", func(b *testing.B) {
+		run(b, func(r *http.Request) (string, error) {
+			upload, err := readUploadForm(r)
+			if err != nil {
+				return "", err
+			}
+			return upload.path, nil
+		})
+	})
+
+	b.Run("parse_and_copy", func(b *testing.B) {
+		run(b, func(r *http.Request) (string, error) {
+			if err := r.ParseMultipartForm(maxUploadFormValueBytes); err != nil {
+				return "", err
+			}
+			defer r.MultipartForm.RemoveAll()
+			file, _, err := r.FormFile("video")
+			if err != nil {
+				return "", err
+			}
+			defer file.Close()
+			tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+			if err != nil {
+				return "", err
+			}
+			defer tempFile.Close()
+			if _, err := io.Copy(tempFile, file); err != nil {
+				os.Remove(tempFile.Name())
+				return "", err
+			}
+			return tempFile.Name(), nil
+		})
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Optional parts of the API operators can switch off with FEATURE_FLAGS.
+const (
+	featureImport         = "import"
+	featureSharing        = "sharing"
+	featureShareLinks     = "share_links"
+	featureClone          = "clone"
+	featureArchive        = "archive"
+	featureFeeds          = "feeds"
+	featureAudioTracks    = "audio_tracks"
+	featureMultipart      = "multipart"
+	featureFrameThumbs    = "thumbnail_from_frame"
+	featureProxyStreaming = "proxy_streaming"
+)
+
+var knownFeatures = []string{
+	featureImport,
+	featureSharing,
+	featureShareLinks,
+	featureClone,
+	featureArchive,
+	featureFeeds,
+	featureAudioTracks,
+	featureMultipart,
+	featureFrameThumbs,
+	featureProxyStreaming,
+}
+
+// featureFlags says which optional features are on. Everything is on unless
+// configured otherwise.
+type featureFlags map[string]bool
+
+// parseFeatureFlags reads a JSON object of feature name to enabled, e.g.
+// {"import":false}, over a default of every feature enabled.
+func parseFeatureFlags(spec string) (featureFlags, error) {
+	flags := make(featureFlags, len(knownFeatures))
+	for _, name := range knownFeatures {
+		flags[name] = true
+	}
+	if spec == "" {
+		return flags, nil
+	}
+
+	var configured map[string]bool
+	if err := json.Unmarshal([]byte(spec), &configured); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	for name, enabled := range configured {
+		if _, ok := flags[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		flags[name] = enabled
+	}
+	return flags, nil
+}
+
+// enabled lists the features that are on, sorted.
+func (f featureFlags) enabled() []string {
+	names := make([]string, 0, len(f))
+	for name, on := range f {
+		if on {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withFeature wraps the handlers of an optional feature so they answer 404
+// while it's switched off, as if the endpoint didn't exist.
+func (cfg *apiConfig) withFeature(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.features[name] {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Feature disabled: %s", name), nil)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxFeedItems caps how many of the newest public videos a feed lists
+const maxFeedItems = 100
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	MediaNS string     `xml:"xmlns:media,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string          `xml:"title"`
+	Description string          `xml:"description"`
+	GUID        rssGUID         `xml:"guid"`
+	PubDate     string          `xml:"pubDate"`
+	Enclosure   rssEnclosure    `xml:"enclosure"`
+	Content     mediaContent    `xml:"media:content"`
+	Thumbnail   *mediaThumbnail `xml:"media:thumbnail,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type mediaContent struct {
+	URL      string `xml:"url,attr"`
+	FileSize int64  `xml:"fileSize,attr,omitempty"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	Duration int    `xml:"duration,attr,omitempty"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+// Serve a user's public videos as a Media RSS feed for feed readers and
+// podcast apps. No authentication: only videos marked public are listed.
+func (cfg *apiConfig) handlerUserFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	videos, err := cfg.db.GetPublicVideos(userID, maxFeedItems)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+
+	feed := rssFeed{
+		Version: "2.0",
+		MediaNS: "http://search.yahoo.com/mrss/",
+		Channel: rssChannel{
+			Title:       "Videos",
+			Link:        fmt.Sprintf("http://localhost:%s/api/users/%s/feed.xml", cfg.port, userID),
+			Description: "Public videos",
+			Items:       make([]rssItem, 0, len(videos)),
+		},
+	}
+	for _, video := range cfg.prepareVideos(videos) {
+		contentType := "video/mp4"
+		if video.ContentType != nil {
+			contentType = *video.ContentType
+		}
+		item := rssItem{
+			Title:       video.Title,
+			Description: video.Description,
+			GUID:        rssGUID{Value: video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:    *video.VideoURL,
+				Length: video.SizeBytes,
+				Type:   contentType,
+			},
+			Content: mediaContent{
+				URL:      *video.VideoURL,
+				FileSize: video.SizeBytes,
+				Type:     contentType,
+				Medium:   "video",
+				Duration: int(video.DurationSeconds + 0.5),
+			},
+		}
+		if video.ThumbnailURL != nil {
+			item.Thumbnail = &mediaThumbnail{URL: *video.ThumbnailURL}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	dat, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(dat)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xaitan80/x-fileserver/internal/ffmpeg"
+)
+
+var errFFmpegStalled = errors.New("no progress / stalled")
+
+// ffmpegStalls counts ffmpeg runs killed by the stall watchdog.
+var ffmpegStalls = expvar.NewInt("ffmpeg_stalls")
+
+// runFFmpeg runs ffmpeg with the given arguments. When stall is positive it
+// watches ffmpeg's -progress output and kills the process, returning
+// errFFmpegStalled, if the output position hasn't moved for that long. That
+// catches a hung process well before the overall context deadline would.
+func runFFmpeg(ctx context.Context, stall time.Duration, args ...string) error {
+	return runFFmpegTo(ctx, stall, nil, args...)
+}
+
+// runFFmpegTo is runFFmpeg for commands whose output is "pipe:3": whatever
+// ffmpeg writes there is copied to out. Stdout stays free for -progress.
+func runFFmpegTo(ctx context.Context, stall time.Duration, out io.Writer, args ...string) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	if stall > 0 {
+		args = append([]string{"-nostats", "-progress", "pipe:1"}, args...)
+	}
+	cmd := ffmpeg.Transcode(ctx, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if l := processingLogFrom(ctx); l != nil {
+		l.printf("$ ffmpeg %s", strings.Join(args, " "))
+		cmd.Stderr = io.MultiWriter(&stderr, l)
+	}
+
+	if stall > 0 {
+		progressReader, progressWriter := io.Pipe()
+		cmd.Stdout = progressWriter
+		defer progressWriter.Close()
+		go watchFFmpegProgress(progressReader, stall, cancel)
+	}
+
+	var copied chan error
+	var outputWriter *os.File
+	if out != nil {
+		outputReader, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		outputWriter = w
+		defer outputWriter.Close()
+		cmd.ExtraFiles = []*os.File{outputWriter}
+		copied = make(chan error, 1)
+		go func() {
+			_, err := io.Copy(out, outputReader)
+			// Closing the read end makes ffmpeg fail fast if out gave up
+			outputReader.Close()
+			copied <- err
+		}()
+	}
+
+	err := cmd.Run()
+	if out != nil {
+		outputWriter.Close()
+		if copyErr := <-copied; err == nil && copyErr != nil {
+			return fmt.Errorf("copying ffmpeg output: %w", copyErr)
+		}
+	}
+	if err != nil {
+		if errors.Is(context.Cause(ctx), errFFmpegStalled) {
+			return errFFmpegStalled
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("ffmpeg aborted: %w", ctx.Err())
+		}
+		return fmt.Errorf("ffmpeg failed: %v, details: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// watchFFmpegProgress reads -progress output until it ends, cancelling with
+// errFFmpegStalled if neither the frame count nor the output position
+// advances within stall. Note that an accurate seek decodes up to its
+// timestamp before writing anything, so stall must allow for that warm-up.
+func watchFFmpegProgress(progress io.Reader, stall time.Duration, cancel context.CancelCauseFunc) {
+	advanced := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		last := map[string]string{}
+		scanner := bufio.NewScanner(progress)
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(scanner.Text(), "=")
+			if !ok || (key != "frame" && key != "out_time_us") {
+				continue
+			}
+			if last[key] == value {
+				continue
+			}
+			last[key] = value
+			select {
+			case advanced <- struct{}{}:
+			default:
+			}
+		}
+		// Keep draining so ffmpeg never blocks writing progress
+		io.Copy(io.Discard, progress)
+	}()
+
+	timer := time.NewTimer(stall)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-advanced:
+			timer.Reset(stall)
+		case <-timer.C:
+			ffmpegStalls.Add(1)
+			cancel(errFFmpegStalled)
+			// The scanner goroutine finishes once the pipe closes after the kill
+			<-done
+			return
+		}
+	}
+}
+
+// Expose process metrics such as ffmpeg_stalls
+func (cfg *apiConfig) handlerAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
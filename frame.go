@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// seekMode picks where -ss goes in an ffmpeg command line.
+//
+// Before -i ("fast") ffmpeg jumps to the nearest keyframe at or before the
+// timestamp without decoding, so it's quick on long videos but the output
+// may start up to a GOP (often several seconds) early. After -i
+// ("accurate") ffmpeg decodes and discards every frame up to the timestamp,
+// landing exactly on it at a cost that grows with the offset.
+type seekMode string
+
+const (
+	seekModeFast     seekMode = "fast"
+	seekModeAccurate seekMode = "accurate"
+)
+
+// parseSeekMode reads a seek mode, falling back to def when raw is empty.
+func parseSeekMode(raw string, def seekMode) (seekMode, error) {
+	switch seekMode(raw) {
+	case "":
+		return def, nil
+	case seekModeFast, seekModeAccurate:
+		return seekMode(raw), nil
+	}
+	return "", fmt.Errorf("seek must be %q or %q", seekModeFast, seekModeAccurate)
+}
+
+// inputArgs returns the ffmpeg arguments that open srcPath positioned at
+// the given offset in seconds.
+func (m seekMode) inputArgs(srcPath string, at float64) []string {
+	ss := strconv.FormatFloat(at, 'f', 3, 64)
+	if m == seekModeAccurate {
+		return []string{"-i", srcPath, "-ss", ss}
+	}
+	return []string{"-ss", ss, "-i", srcPath}
+}
+
+// extractFrame writes the frame at the given offset in seconds to a JPEG,
+// returning the path of the new file.
+func extractFrame(ctx context.Context, srcPath string, at float64, mode seekMode, stall time.Duration) (string, error) {
+	outputPath := srcPath + ".frame.jpg"
+	args := mode.inputArgs(srcPath, at)
+	args = append(args,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y", outputPath,
+	)
+
+	if err := runFFmpeg(ctx, stall, args...); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg frame extraction failed: %w", err)
+	}
+
+	// Seeking past the end isn't an error to ffmpeg; it just writes nothing
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("no frame at %.3fs", at)
+	}
+	return outputPath, nil
+}
+
+// defaultFrameAt is where the thumbnail frame is taken when no time is given:
+// far enough in to skip a fade from black.
+const defaultFrameAt = 1.0
+
+// Set a video's thumbnail to the frame at ?t=<seconds> (or ?at=), one second
+// in by default. Seeking defaults to accurate so users get exactly the frame
+// they picked; ?seek=fast trades that for speed on long videos.
+func (cfg *apiConfig) handlerThumbnailFromFrame(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	at, explicit := defaultFrameAt, false
+	param := "t"
+	raw := r.URL.Query().Get(param)
+	if raw == "" {
+		param = "at"
+		raw = r.URL.Query().Get(param)
+	}
+	if raw != "" {
+		at, err = strconv.ParseFloat(raw, 64)
+		if err != nil || at < 0 {
+			respondWithError(w, http.StatusBadRequest, param+" must be a non-negative number of seconds", err)
+			return
+		}
+		explicit = true
+	}
+	mode, err := parseSeekMode(r.URL.Query().Get("seek"), seekModeAccurate)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+	target, key, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in our bucket", nil)
+		return
+	}
+
+	srcPath, err := target.downloadObject(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(srcPath)
+
+	// Ask ffprobe rather than trusting the stored duration, which older
+	// uploads don't have
+	duration, err := getVideoDuration(r.Context(), srcPath)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Couldn't read video duration", err)
+		return
+	}
+	if at >= duration {
+		if explicit {
+			respondWithError(w, http.StatusBadRequest,
+				fmt.Sprintf("t is past the end of the %.3fs video", duration), nil)
+			return
+		}
+		// Clips shorter than the default use their middle frame
+		at = duration / 2
+	}
+
+	framePath, err := extractFrame(r.Context(), srcPath, at, mode, cfg.ffmpegStallTimeout)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Couldn't extract frame", err)
+		return
+	}
+	defer os.Remove(framePath)
+
+	data, err := os.ReadFile(framePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read frame", err)
+		return
+	}
+	var blurHash *string
+	if hash, err := thumbnailBlurHash(data); err != nil {
+		log.Printf("Couldn't compute blurhash for video %s: %v", videoID, err)
+	} else {
+		blurHash = &hash
+	}
+
+	ext, mediaType := ".jpg", "image/jpeg"
+	if cfg.thumbnailFormat != nil {
+		data, err = cfg.thumbnailFormat.convert(r.Context(), framePath, cfg.thumbnailQuality, cfg.ffmpegStallTimeout)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't convert frame", err)
+			return
+		}
+		ext, mediaType = cfg.thumbnailFormat.ext, cfg.thumbnailFormat.mediaType
+	}
+
+	name, err := randomObjectName()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate random filename", err)
+		return
+	}
+	url, err := cfg.storeThumbnail(r.Context(), name+ext, bytes.NewReader(data), mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file", err)
+		return
+	}
+
+	var size *database.ThumbnailSize
+	if s, err := thumbnailSize(data); err != nil {
+		log.Printf("Couldn't read thumbnail size for video %s: %v", videoID, err)
+	} else {
+		size = &s
+	}
+
+	if err := cfg.db.SetThumbnailURL(videoID, url, blurHash, size); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video", err)
+		return
+	}
+	cfg.audit.record(&userID, videoID, auditActionUploadThumbnail)
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to get video", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
+}
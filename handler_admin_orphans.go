@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaultOrphanMinAge leaves out objects uploaded so recently that their
+// video may not point at them yet.
+const defaultOrphanMinAge = time.Hour
+
+type orphanObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// storedKeys indexes the objects videos reference in one bucket. DASH
+// segments aren't recorded individually, so any object in the directory of
+// a referenced manifest counts as referenced.
+type storedKeys struct {
+	keys     map[string]bool
+	dashDirs map[string]bool
+}
+
+func (cfg *apiConfig) loadStoredKeys(target *storageTarget) (storedKeys, error) {
+	stored := storedKeys{keys: map[string]bool{}, dashDirs: map[string]bool{}}
+	urls, err := cfg.db.GetStoredURLs()
+	if err != nil {
+		return stored, err
+	}
+	for _, u := range urls {
+		t, key, ok := cfg.locateObject(u)
+		if !ok || t != target {
+			continue
+		}
+		stored.keys[key] = true
+		if path.Base(key) == dashManifestName {
+			stored.dashDirs[path.Dir(key)] = true
+		}
+	}
+	return stored, nil
+}
+
+func (s storedKeys) referenced(key string) bool {
+	return s.keys[key] || s.dashDirs[path.Dir(key)]
+}
+
+// orphans picks the unreferenced objects older than minAge out of a listing.
+func (s storedKeys) orphans(objects []types.Object, minAge time.Duration) []orphanObject {
+	cutoff := time.Now().Add(-minAge)
+	found := []orphanObject{}
+	for _, obj := range objects {
+		if obj.Key == nil || s.referenced(*obj.Key) {
+			continue
+		}
+		if obj.LastModified != nil && obj.LastModified.After(cutoff) {
+			continue
+		}
+		orphan := orphanObject{Key: *obj.Key}
+		if obj.Size != nil {
+			orphan.Size = *obj.Size
+		}
+		if obj.LastModified != nil {
+			orphan.LastModified = *obj.LastModified
+		}
+		found = append(found, orphan)
+	}
+	return found
+}
+
+// orphanScanTarget resolves the bucket to scan, defaulting to the primary one.
+func (cfg *apiConfig) orphanScanTarget(bucket string) (*storageTarget, bool) {
+	if bucket == "" {
+		return cfg.storageTargets[0], true
+	}
+	return cfg.targetByBucket(bucket)
+}
+
+// List one page of a bucket's objects that no video references. Follow
+// next_cursor for the rest of the bucket.
+func (cfg *apiConfig) handlerAdminOrphans(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Bucket     string         `json:"bucket"`
+		Orphans    []orphanObject `json:"orphans"`
+		NextCursor *string        `json:"next_cursor"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	query := r.URL.Query()
+	target, ok := cfg.orphanScanTarget(query.Get("bucket"))
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown bucket", nil)
+		return
+	}
+	minAge := defaultOrphanMinAge
+	if v := query.Get("min_age"); v != "" {
+		var err error
+		if minAge, err = time.ParseDuration(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid min_age", err)
+			return
+		}
+	}
+	var cursor *string
+	if v := query.Get("cursor"); v != "" {
+		cursor = &v
+	}
+
+	stored, err := cfg.loadStoredKeys(target)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load stored keys", err)
+		return
+	}
+	objects, next, err := target.listObjectsPage(r.Context(), query.Get("prefix"), cursor)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list bucket", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Bucket:     target.bucket,
+		Orphans:    stored.orphans(objects, minAge),
+		NextCursor: next,
+	})
+}
+
+// Delete every object in a bucket that no video references. Nothing is
+// deleted unless the body sets "dry_run": false.
+func (cfg *apiConfig) handlerAdminOrphansPurge(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Bucket string `json:"bucket"`
+		Prefix string `json:"prefix"`
+		MinAge string `json:"min_age"`
+		DryRun *bool  `json:"dry_run"`
+	}
+	type response struct {
+		Bucket  string         `json:"bucket"`
+		DryRun  bool           `json:"dry_run"`
+		Orphans []orphanObject `json:"orphans"`
+		Deleted int            `json:"deleted"`
+		Failed  []string       `json:"failed"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	target, ok := cfg.orphanScanTarget(params.Bucket)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown bucket", nil)
+		return
+	}
+	minAge := defaultOrphanMinAge
+	if params.MinAge != "" {
+		var err error
+		if minAge, err = time.ParseDuration(params.MinAge); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid min_age", err)
+			return
+		}
+	}
+	dryRun := params.DryRun == nil || *params.DryRun
+
+	stored, err := cfg.loadStoredKeys(target)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load stored keys", err)
+		return
+	}
+	orphans := []orphanObject{}
+	var cursor *string
+	for {
+		objects, next, err := target.listObjectsPage(r.Context(), params.Prefix, cursor)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't list bucket", err)
+			return
+		}
+		orphans = append(orphans, stored.orphans(objects, minAge)...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	resp := response{Bucket: target.bucket, DryRun: dryRun, Orphans: orphans, Failed: []string{}}
+	if !dryRun && len(orphans) > 0 {
+		keys := make([]string, 0, len(orphans))
+		for _, orphan := range orphans {
+			keys = append(keys, orphan.Key)
+		}
+		failed, err := target.deleteObjects(r.Context(), keys)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete orphans", err)
+			return
+		}
+		resp.Deleted = len(keys) - len(failed)
+		if failed != nil {
+			resp.Failed = failed
+		}
+		log.Printf("Purged %d orphaned objects from %s (prefix %q)", resp.Deleted, target.bucket, params.Prefix)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	defaultS3PingSample = 5
+	maxS3PingSample     = 50
+	s3PingTimeout       = 10 * time.Second
+)
+
+type s3PingObject struct {
+	Key          string     `json:"key"`
+	Size         int64      `json:"size"`
+	LastModified *time.Time `json:"last_modified"`
+}
+
+type s3PingResult struct {
+	Bucket    string         `json:"bucket"`
+	Region    string         `json:"region"`
+	Role      string         `json:"role"`
+	OK        bool           `json:"ok"`
+	HeadError string         `json:"head_error,omitempty"`
+	ListError string         `json:"list_error,omitempty"`
+	Sample    []s3PingObject `json:"sample"`
+}
+
+// describeS3Error turns an SDK error into something an operator can act on,
+// leading with S3's error code when there is one.
+func describeS3Error(err error) string {
+	var apiErr apiErrorCoder
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "Forbidden":
+			return fmt.Sprintf("%s: the credentials aren't allowed to do this (%v)", apiErr.ErrorCode(), err)
+		case "NoSuchBucket", "NotFound":
+			return fmt.Sprintf("%s: the bucket doesn't exist in this region (%v)", apiErr.ErrorCode(), err)
+		case "PermanentRedirect", "AuthorizationHeaderMalformed":
+			return fmt.Sprintf("%s: the bucket is in a different region (%v)", apiErr.ErrorCode(), err)
+		}
+		return fmt.Sprintf("%s: %v", apiErr.ErrorCode(), err)
+	}
+	return err.Error()
+}
+
+// ping checks the bucket is reachable and lists up to sample keys under
+// prefix. Only keys and sizes are reported, never URLs.
+func (t *storageTarget) ping(ctx context.Context, prefix string, sample int) s3PingResult {
+	result := s3PingResult{Bucket: t.bucket, Region: t.region, Sample: []s3PingObject{}}
+
+	if _, err := t.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &t.bucket}); err != nil {
+		result.HeadError = describeS3Error(err)
+	}
+	maxKeys := int32(sample)
+	out, err := t.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  &t.bucket,
+		Prefix:  &prefix,
+		MaxKeys: &maxKeys,
+	})
+	if err != nil {
+		result.ListError = describeS3Error(err)
+	} else {
+		for _, obj := range out.Contents {
+			item := s3PingObject{LastModified: obj.LastModified}
+			if obj.Key != nil {
+				item.Key = *obj.Key
+			}
+			if obj.Size != nil {
+				item.Size = *obj.Size
+			}
+			result.Sample = append(result.Sample, item)
+		}
+	}
+	result.OK = result.HeadError == "" && result.ListError == ""
+	return result
+}
+
+// Check every configured bucket: HeadBucket, then list a few keys under
+// ?prefix=. Responds 200 when all are reachable and 502 otherwise, with
+// each bucket's errors spelled out either way.
+func (cfg *apiConfig) handlerAdminS3Ping(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		OK      bool           `json:"ok"`
+		Buckets []s3PingResult `json:"buckets"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	sample := defaultS3PingSample
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, "limit must be a positive integer", err)
+			return
+		}
+		sample = min(n, maxS3PingSample)
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	ctx, cancel := context.WithTimeout(r.Context(), s3PingTimeout)
+	defer cancel()
+
+	resp := response{OK: true}
+	for _, target := range cfg.storageTargets {
+		result := target.ping(ctx, prefix, sample)
+		result.Role = "uploads"
+		resp.Buckets = append(resp.Buckets, result)
+	}
+	for class, target := range cfg.assetTargets {
+		result := target.ping(ctx, prefix, sample)
+		result.Role = string(class)
+		resp.Buckets = append(resp.Buckets, result)
+	}
+	for _, result := range resp.Buckets {
+		resp.OK = resp.OK && result.OK
+	}
+
+	code := http.StatusOK
+	if !resp.OK {
+		code = http.StatusBadGateway
+	}
+	respondWithJSON(w, code, resp)
+}
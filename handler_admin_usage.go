@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+type userUsageChange struct {
+	UserID uuid.UUID `json:"user_id"`
+	Before int64     `json:"before"`
+	After  int64     `json:"after"`
+}
+
+// usageRecompute tracks the progress of the background usage reconciliation.
+type usageRecompute struct {
+	mu         sync.Mutex
+	Running    bool              `json:"running"`
+	StartedAt  *time.Time        `json:"started_at"`
+	FinishedAt *time.Time        `json:"finished_at"`
+	UsersDone  int               `json:"users_done"`
+	UsersTotal int               `json:"users_total"`
+	Changes    []userUsageChange `json:"changes"`
+	Errors     []string          `json:"errors"`
+}
+
+func (u *usageRecompute) snapshot() usageRecompute {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return usageRecompute{
+		Running:    u.Running,
+		StartedAt:  u.StartedAt,
+		FinishedAt: u.FinishedAt,
+		UsersDone:  u.UsersDone,
+		UsersTotal: u.UsersTotal,
+		Changes:    append([]userUsageChange{}, u.Changes...),
+		Errors:     append([]string{}, u.Errors...),
+	}
+}
+
+func (u *usageRecompute) addError(err error) {
+	log.Printf("Usage recompute: %v", err)
+	u.mu.Lock()
+	u.Errors = append(u.Errors, err.Error())
+	u.mu.Unlock()
+}
+
+// Start rewriting every user's storage counter from the sizes of their stored objects
+func (cfg *apiConfig) handlerAdminRecomputeUsage(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	job := cfg.usageRecompute
+	job.mu.Lock()
+	if job.Running {
+		job.mu.Unlock()
+		respondWithError(w, http.StatusConflict, "Usage recompute already running", nil)
+		return
+	}
+	now := time.Now().UTC()
+	job.Running = true
+	job.StartedAt = &now
+	job.FinishedAt = nil
+	job.UsersDone = 0
+	job.UsersTotal = 0
+	job.Changes = nil
+	job.Errors = nil
+	job.mu.Unlock()
+
+	go cfg.recomputeUsage(context.Background())
+
+	respondWithJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+// Report progress and before/after results of the latest usage recompute
+func (cfg *apiConfig) handlerAdminRecomputeUsageStatus(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, cfg.usageRecompute.snapshot())
+}
+
+func (cfg *apiConfig) recomputeUsage(ctx context.Context) {
+	job := cfg.usageRecompute
+	defer func() {
+		now := time.Now().UTC()
+		job.mu.Lock()
+		job.Running = false
+		job.FinishedAt = &now
+		job.mu.Unlock()
+	}()
+
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		job.addError(err)
+		return
+	}
+	job.mu.Lock()
+	job.UsersTotal = len(users)
+	job.mu.Unlock()
+
+	for _, user := range users {
+		total, err := cfg.measureUserStorage(ctx, user.ID)
+		if err != nil {
+			job.addError(err)
+		} else if err = cfg.db.SetUserStorageBytes(user.ID, total); err != nil {
+			job.addError(err)
+		} else {
+			cfg.checkQuota(user.ID)
+		}
+
+		job.mu.Lock()
+		job.UsersDone++
+		if err == nil {
+			job.Changes = append(job.Changes, userUsageChange{
+				UserID: user.ID,
+				Before: user.StorageBytes,
+				After:  total,
+			})
+		}
+		job.mu.Unlock()
+	}
+}
+
+// measureUserStorage sums the real size of each of the user's stored video
+// objects, correcting the per-video sizes along the way.
+func (cfg *apiConfig) measureUserStorage(ctx context.Context, userID uuid.UUID) (int64, error) {
+	videos, err := cfg.db.GetVideos(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, video := range videos {
+		var size int64
+		if video.VideoURL != nil {
+			if target, key, ok := cfg.locateObject(*video.VideoURL); ok {
+				head, err := target.client.HeadObject(ctx, &s3.HeadObjectInput{
+					Bucket: &target.bucket,
+					Key:    &key,
+				})
+				var notFound *types.NotFound
+				if err != nil && !errors.As(err, &notFound) {
+					return 0, err
+				}
+				if err == nil && head.ContentLength != nil {
+					size = *head.ContentLength
+				}
+			}
+		}
+		if size != video.SizeBytes {
+			if err := cfg.db.SetVideoSize(video.ID, size); err != nil {
+				return 0, err
+			}
+		}
+		total += size
+	}
+	return total, nil
+}
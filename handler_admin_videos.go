@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+// Find the video that owns an S3 object, flagging objects with no video as orphans
+func (cfg *apiConfig) handlerAdminVideoByKey(w http.ResponseWriter, r *http.Request) {
+	type notFoundResponse struct {
+		Error  string `json:"error"`
+		Orphan bool   `json:"orphan"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	key := r.URL.Query().Get("key")
+	if bucket == "" || key == "" {
+		respondWithError(w, http.StatusBadRequest, "bucket and key are required", nil)
+		return
+	}
+
+	target, ok := cfg.targetByBucket(bucket)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown bucket", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideoByStorageKey(target.objectURL(key))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up video", err)
+		return
+	}
+	if video.ID != uuid.Nil {
+		cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
+		return
+	}
+
+	// No video references the key; it's an orphan if the object exists
+	_, err = target.client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: &target.bucket,
+		Key:    &key,
+	})
+	var notFound *types.NotFound
+	if err != nil && !errors.As(err, &notFound) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check object", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNotFound, notFoundResponse{
+		Error:  "No video references this object",
+		Orphan: err == nil,
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// maxAudioTrackSize caps a single uploaded audio track.
+const maxAudioTrackSize = 512 << 20 // 512MB
+
+// audioTrackExtensions are the accepted audio track types and the
+// extensions their objects get.
+var audioTrackExtensions = map[string]string{
+	"audio/mp4":  ".m4a",
+	"audio/aac":  ".aac",
+	"audio/mpeg": ".mp3",
+	"audio/ogg":  ".ogg",
+	"audio/webm": ".weba",
+	"audio/opus": ".opus",
+}
+
+// languageTagPattern matches the common shapes of a BCP 47 tag: a 2-3
+// letter language, then optional script, region and variant subtags.
+var languageTagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-([a-zA-Z]{2}|[0-9]{3}))?(-([a-zA-Z0-9]{5,8}|[0-9][a-zA-Z0-9]{3}))*$`)
+
+// normalizeLanguageTag validates a BCP 47 language tag and returns it in
+// canonical case ("en-us" becomes "en-US", "zh-hant" becomes "zh-Hant"),
+// so the same language can't be added twice under different spellings.
+func normalizeLanguageTag(tag string) (string, error) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	if !languageTagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%q isn't a valid language tag, want e.g. \"en\" or \"pt-BR\"", tag)
+	}
+	subtags := strings.Split(tag, "-")
+	subtags[0] = strings.ToLower(subtags[0])
+	for i := 1; i < len(subtags); i++ {
+		s := subtags[i]
+		switch {
+		case len(s) == 4 && i == 1 && !strings.ContainsAny(s[:1], "0123456789"):
+			subtags[i] = strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+		case len(s) == 2:
+			subtags[i] = strings.ToUpper(s)
+		default:
+			subtags[i] = strings.ToLower(s)
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// Attach an extra language track to a video. The multipart form carries the
+// file as "audio", its BCP 47 "language" tag and an optional display "label".
+func (cfg *apiConfig) handlerAudioTrackUpload(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Upload the video before adding audio tracks", nil)
+		return
+	}
+	target, _, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in our bucket", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAudioTrackSize)
+	const maxMemory = 10 << 20 // 10MB
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Audio track is too large", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Failed to parse form", err)
+		return
+	}
+
+	language, err := normalizeLanguageTag(r.FormValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	label := strings.TrimSpace(r.FormValue("label"))
+	if len(label) > 100 {
+		respondWithError(w, http.StatusBadRequest, "label must be at most 100 characters", nil)
+		return
+	}
+
+	existing, err := cfg.db.GetAudioTrack(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audio tracks", err)
+		return
+	}
+	if existing.URL != "" {
+		respondWithError(w, http.StatusConflict, "Video already has an audio track in this language", nil)
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("audio")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Missing audio file", err)
+		return
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		return
+	}
+	ext, ok := audioTrackExtensions[mediaType]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Unsupported audio track type", nil)
+		return
+	}
+
+	// The upload is probed before it's stored, so a mislabelled file can't
+	// end up in the manifest
+	tempFile, err := os.CreateTemp("", "tubely-audio-*"+ext)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temp file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	if _, err := io.Copy(tempFile, file); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save audio track", err)
+		return
+	}
+	hasAudio, err := hasAudioStream(r.Context(), tempFile.Name())
+	if err != nil || !hasAudio {
+		respondWithError(w, http.StatusBadRequest, "File doesn't contain an audio stream", err)
+		return
+	}
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read audio track", err)
+		return
+	}
+
+	name, err := randomObjectName()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate object key", err)
+		return
+	}
+	key := "audio/" + name + ext
+	if err := target.putObject(r.Context(), key, tempFile, mediaType); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't upload audio track", err)
+		return
+	}
+
+	track, err := cfg.db.CreateAudioTrack(database.CreateAudioTrackParams{
+		VideoID:  video.ID,
+		Language: language,
+		Label:    label,
+		URL:      target.objectURL(key),
+	})
+	if err != nil {
+		// A concurrent upload won the race for this language
+		if delErr := target.deleteObject(r.Context(), key); delErr != nil {
+			log.Printf("Couldn't delete unused audio track %s: %v", key, delErr)
+		}
+		if errors.Is(err, database.ErrAudioTrackExists) {
+			respondWithError(w, http.StatusConflict, "Video already has an audio track in this language", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save audio track", err)
+		return
+	}
+	cfg.audit.record(&userID, video.ID, auditActionAddAudioTrack)
+
+	respondWithJSON(w, http.StatusCreated, track)
+}
+
+// Remove a video's audio track in a language
+func (cfg *apiConfig) handlerAudioTrackDelete(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	language, err := normalizeLanguageTag(r.PathValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	track, err := cfg.db.GetAudioTrack(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audio track", err)
+		return
+	}
+	deleted, err := cfg.db.DeleteAudioTrack(video.ID, language)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete audio track", err)
+		return
+	}
+	if !deleted {
+		respondWithError(w, http.StatusNotFound, "Audio track not found", nil)
+		return
+	}
+	cfg.audit.record(&userID, video.ID, auditActionRemoveAudioTrack)
+
+	if target, key, ok := cfg.locateObject(track.URL); ok {
+		if err := target.deleteObject(r.Context(), key); err != nil {
+			log.Printf("Couldn't delete audio track %s for video %s: %v", language, video.ID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// Get the audit history of a single video (owner or admin)
+func (cfg *apiConfig) handlerVideoHistory(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	// Admins can see the history of any video, including deleted ones
+	if _, err := auth.GetAPIKey(r.Header); err == nil {
+		if err := cfg.authorizeAdmin(r); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+			return
+		}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Video not found", nil)
+			return
+		}
+		if video.UserID != userID {
+			respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+			return
+		}
+	}
+
+	limit, offset, err := parsePagination(r, 50, 500)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	events, err := cfg.db.GetAuditEvents(database.AuditFilter{
+		VideoID: &videoID,
+		Limit:   limit,
+		Offset:  offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get history", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+// Query the audit log across all users, filtered by actor_id, video_id,
+// action and an RFC 3339 since/until window
+func (cfg *apiConfig) handlerAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, 50, 500)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	filter := database.AuditFilter{
+		Action: r.URL.Query().Get("action"),
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	query := r.URL.Query()
+	if v := query.Get("actor_id"); v != "" {
+		actorID, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid actor_id", err)
+			return
+		}
+		filter.ActorID = &actorID
+	}
+	if v := query.Get("video_id"); v != "" {
+		videoID, err := uuid.Parse(v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid video_id", err)
+			return
+		}
+		filter.VideoID = &videoID
+	}
+	if v := query.Get("since"); v != "" {
+		filter.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp", err)
+			return
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		filter.Until, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "until must be an RFC 3339 timestamp", err)
+			return
+		}
+	}
+
+	events, err := cfg.db.GetAuditEvents(filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't query audit log", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, events)
+}
@@ -1,6 +1,158 @@
 package main
 
-// This file is intentionally left blank. The `/api/thumbnails/{videoID}` endpoint
-// has been removed, and thumbnail data is stored directly in the Video's
-// ThumbnailURL field as a data URI. We keep this file to avoid build errors
-// when updating existing projects that might still reference it.
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// Stream a video's thumbnail bytes for clients that can't fetch the asset
+// URL themselves. Local assets are served from disk and S3 objects proxied;
+// both honour Range requests.
+func (cfg *apiConfig) handlerThumbnailGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+	if video.ThumbnailURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail", nil)
+		return
+	}
+
+	if target, key, ok := cfg.locateObject(*video.ThumbnailURL); ok {
+		proxyObject(w, r, target, key)
+		return
+	}
+
+	u, err := url.Parse(*video.ThumbnailURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/assets/") {
+		respondWithError(w, http.StatusConflict, "Thumbnail isn't stored by this server", err)
+		return
+	}
+	name := path.Base(u.Path)
+	file, err := os.Open(filepath.Join(cfg.assetsRoot, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			respondWithError(w, http.StatusNotFound, "Thumbnail file is missing", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open thumbnail", err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't stat thumbnail", err)
+		return
+	}
+
+	// ServeContent sets Content-Type from the extension and handles ranges
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// Return just the URL of a video's thumbnail, so list views can load
+// thumbnails lazily without fetching whole records. Thumbnail URLs are
+// unsigned, so expires_at is always null. The response carries an ETag and
+// may be cached briefly by the client.
+func (cfg *apiConfig) handlerThumbnailURLGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL       string     `json:"url"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+	video = cfg.prepareVideo(video)
+	if video.ThumbnailURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no thumbnail", nil)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(*video.ThumbnailURL))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{URL: *video.ThumbnailURL})
+}
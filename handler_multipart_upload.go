@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	// multipartPartURLTTL is how long a presigned part URL can be used
+	multipartPartURLTTL = time.Hour
+	// maxMultipartParts is S3's limit on parts per upload
+	maxMultipartParts = 10000
+	// multipartFinishTimeout bounds fetching and processing the assembled file
+	multipartFinishTimeout = 2 * time.Hour
+)
+
+// getMultipartUpload loads the caller's video and its multipart upload
+// along with the bucket the upload is going to, writing an error response
+// and returning ok=false if any of them is missing.
+func (cfg *apiConfig) getMultipartUpload(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, database.MultipartUpload, *storageTarget, bool) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return database.Video{}, uuid.Nil, database.MultipartUpload{}, nil, false
+	}
+	upload, err := cfg.db.GetMultipartUpload(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get multipart upload", err)
+		return database.Video{}, uuid.Nil, database.MultipartUpload{}, nil, false
+	}
+	if upload.UploadID == "" {
+		respondWithError(w, http.StatusNotFound, "No multipart upload in progress for this video", nil)
+		return database.Video{}, uuid.Nil, database.MultipartUpload{}, nil, false
+	}
+	target, ok := cfg.targetByBucket(upload.Bucket)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Multipart upload's bucket is no longer configured",
+			fmt.Errorf("unknown bucket %s", upload.Bucket))
+		return database.Video{}, uuid.Nil, database.MultipartUpload{}, nil, false
+	}
+	return video, userID, upload, target, true
+}
+
+// Start sending a video's file straight to S3 in parts. If the video already
+// has a multipart upload in progress, that one is returned so the client can
+// resume it.
+func (cfg *apiConfig) handlerMultipartStart(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if code, msg, err := cfg.checkUploadTicket(r, userID, video.ID); code != 0 {
+		respondWithError(w, code, msg, err)
+		return
+	}
+	if video.Status.Busy() {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+
+	existing, err := cfg.db.GetMultipartUpload(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get multipart upload", err)
+		return
+	}
+	if existing.UploadID != "" {
+		respondWithJSON(w, http.StatusOK, existing)
+		return
+	}
+
+	// Parts land under a staging key; the finished file is processed and
+	// stored under its real key like any other upload
+	target := cfg.uploadTarget(r)
+	name, err := randomObjectName()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
+		return
+	}
+	key := "multipart/" + name + ".mp4"
+	uploadID, err := target.startMultipart(r.Context(), key, "video/mp4")
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't start multipart upload", err)
+		return
+	}
+	upload, err := cfg.db.CreateMultipartUpload(database.CreateMultipartUploadParams{
+		VideoID:  video.ID,
+		UploadID: uploadID,
+		Bucket:   target.bucket,
+		Key:      key,
+	})
+	if err != nil {
+		if abortErr := target.abortMultipart(r.Context(), key, uploadID); abortErr != nil {
+			log.Printf("Couldn't abort multipart upload %s: %v", uploadID, abortErr)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save multipart upload", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, upload)
+}
+
+// Presign the URL for part ?n= of a video's multipart upload. The client
+// PUTs the part's bytes there and keeps the ETag header S3 answers with.
+func (cfg *apiConfig) handlerMultipartPart(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		PartNumber int       `json:"part_number"`
+		URL        string    `json:"url"`
+		ExpiresAt  time.Time `json:"expires_at"`
+	}
+
+	_, _, upload, target, ok := cfg.getMultipartUpload(w, r)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n < 1 || n > maxMultipartParts {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("n must be a part number from 1 to %d", maxMultipartParts), err)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(multipartPartURLTTL)
+	u, err := target.presignPart(r.Context(), upload.Key, upload.UploadID, int32(n), multipartPartURLTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign part URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{PartNumber: n, URL: u, ExpiresAt: expiresAt})
+}
+
+// List the parts S3 already has, so an interrupted client knows which ones
+// to send again
+func (cfg *apiConfig) handlerMultipartParts(w http.ResponseWriter, r *http.Request) {
+	type part struct {
+		PartNumber int32  `json:"part_number"`
+		ETag       string `json:"etag"`
+		Size       int64  `json:"size"`
+	}
+	type response struct {
+		database.MultipartUpload
+		Parts []part `json:"parts"`
+	}
+
+	_, _, upload, target, ok := cfg.getMultipartUpload(w, r)
+	if !ok {
+		return
+	}
+	uploaded, err := target.listParts(r.Context(), upload.Key, upload.UploadID)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't list uploaded parts", err)
+		return
+	}
+
+	resp := response{MultipartUpload: upload, Parts: make([]part, 0, len(uploaded))}
+	for _, p := range uploaded {
+		resp.Parts = append(resp.Parts, part{
+			PartNumber: aws.ToInt32(p.PartNumber),
+			ETag:       aws.ToString(p.ETag),
+			Size:       aws.ToInt64(p.Size),
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// Assemble the sent parts into the video's file, then probe, transcode and
+// store it in the background like an import. Responds with the job to poll.
+func (cfg *apiConfig) handlerMultipartComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Parts []struct {
+			PartNumber int32  `json:"part_number"`
+			ETag       string `json:"etag"`
+		} `json:"parts"`
+		Profile string `json:"profile"`
+	}
+
+	video, userID, upload, target, ok := cfg.getMultipartUpload(w, r)
+	if !ok {
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if len(params.Parts) == 0 {
+		respondWithError(w, http.StatusBadRequest, "parts is required", nil)
+		return
+	}
+	parts := make([]types.CompletedPart, 0, len(params.Parts))
+	for i, p := range params.Parts {
+		if p.ETag == "" || p.PartNumber < 1 || (i > 0 && p.PartNumber <= params.Parts[i-1].PartNumber) {
+			respondWithError(w, http.StatusBadRequest, "parts must be in ascending part_number order, each with its etag", nil)
+			return
+		}
+		parts = append(parts, types.CompletedPart{ETag: aws.String(p.ETag), PartNumber: aws.Int32(p.PartNumber)})
+	}
+	if params.Profile == "" {
+		params.Profile = cfg.transcodeProfileFor(userID)
+	}
+	profile, ok := cfg.transcodeProfiles[params.Profile]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown transcode profile %q", params.Profile), nil)
+		return
+	}
+	if video.Status.Busy() {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+	// Claim the video before assembling the parts, so only one of several
+	// concurrent completes goes ahead; the others see it busy
+	claimed, err := cfg.db.SwapStatus(video.ID, video.Status, database.VideoStatusProcessing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video status", err)
+		return
+	}
+	if !claimed {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		if _, err := cfg.db.SwapStatus(video.ID, database.VideoStatusProcessing, video.Status); err != nil {
+			log.Printf("Couldn't restore status of video %s: %v", video.ID, err)
+		}
+	}()
+
+	if err := target.completeMultipart(r.Context(), upload.Key, upload.UploadID, parts); err != nil {
+		// A wrong or missing part can be sent again; anything else means the
+		// upload can't be finished, so its parts are discarded
+		var apiErr apiErrorCoder
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "InvalidPart" || apiErr.ErrorCode() == "InvalidPartOrder" || apiErr.ErrorCode() == "EntityTooSmall") {
+			respondWithError(w, http.StatusBadRequest, "S3 rejected the parts: "+apiErr.ErrorCode(), err)
+			return
+		}
+		cfg.abortMultipartUpload(r.Context(), upload, target)
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete multipart upload", err)
+		return
+	}
+	if err := cfg.db.DeleteMultipartUpload(video.ID); err != nil {
+		log.Printf("Couldn't forget completed multipart upload for video %s: %v", video.ID, err)
+	}
+
+	started = true
+	j := cfg.jobs.start("multipart", userID, video.ID)
+	go cfg.runMultipartFinish(j, video, upload.Key, profile, target)
+
+	respondWithJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// Abort a video's multipart upload, discarding the parts sent so far
+func (cfg *apiConfig) handlerMultipartAbort(w http.ResponseWriter, r *http.Request) {
+	_, _, upload, target, ok := cfg.getMultipartUpload(w, r)
+	if !ok {
+		return
+	}
+	if err := target.abortMultipart(r.Context(), upload.Key, upload.UploadID); err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't abort multipart upload", err)
+		return
+	}
+	if err := cfg.db.DeleteMultipartUpload(upload.VideoID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete multipart upload", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// abortMultipartUpload discards an upload that can't be completed. It's
+// best effort; S3 lifecycle rules clean up anything left behind.
+func (cfg *apiConfig) abortMultipartUpload(ctx context.Context, upload database.MultipartUpload, target *storageTarget) {
+	if err := target.abortMultipart(ctx, upload.Key, upload.UploadID); err != nil {
+		log.Printf("Couldn't abort multipart upload for video %s: %v", upload.VideoID, err)
+	}
+	if err := cfg.db.DeleteMultipartUpload(upload.VideoID); err != nil {
+		log.Printf("Couldn't forget multipart upload for video %s: %v", upload.VideoID, err)
+	}
+}
+
+// runMultipartFinish fetches an assembled multipart upload and ingests it,
+// recording the outcome on both the job and the video. The staging object
+// is deleted either way.
+func (cfg *apiConfig) runMultipartFinish(j *job, video database.Video, key string, profile transcodeProfile, target *storageTarget) {
+	defer cfg.recoverJob(j, video.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), multipartFinishTimeout)
+	defer cancel()
+	ctx, untrack := cfg.jobs.trackUpload(ctx, video.ID)
+	defer untrack()
+	defer func() {
+		if err := target.deleteObject(context.Background(), key); err != nil {
+			log.Printf("Couldn't delete multipart staging object %s: %v", key, err)
+		}
+	}()
+
+	failReason := "upload failed"
+	err := func() error {
+		// The parts were never seen by us, so hold the assembled file to
+		// the same limit as direct uploads before pulling it onto disk
+		size, err := target.objectSize(ctx, key)
+		if err != nil {
+			return fmt.Errorf("couldn't read size of assembled upload: %w", err)
+		}
+		if size > maxVideoUploadBytes {
+			failReason = "upload too large"
+			return fmt.Errorf("assembled upload is %d bytes; the limit is %d", size, int64(maxVideoUploadBytes))
+		}
+		if err := checkDiskFree(os.TempDir(), size); err != nil {
+			return err
+		}
+
+		j.setStage("fetching")
+		srcPath, err := target.downloadObject(ctx, key)
+		if err != nil {
+			return fmt.Errorf("couldn't fetch assembled upload: %w", err)
+		}
+		defer os.Remove(srcPath)
+
+		// Parts go straight to S3 without a type of their own, so the type is
+		// left for ingest to detect from the file
+		err = cfg.ingestVideo(ctx, ingestRequest{
+			video:   video,
+			userID:  video.UserID,
+			srcPath: srcPath,
+			profile: profile,
+			target:  target,
+			stage:   j.setStage,
+			queuePosition: func(position int) {
+				j.update(func(s *jobState) { s.QueuePosition = position })
+			},
+		})
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) {
+			failReason = ingestErr.reason
+			return errors.New(ingestErr.message)
+		}
+		return err
+	}()
+	if err != nil && context.Cause(ctx) == errUploadCancelled {
+		failReason = "upload cancelled"
+		err = errUploadCancelled
+	}
+
+	j.finish(err)
+	if err == nil {
+		return
+	}
+	log.Printf("Multipart upload of video %s failed: %v", video.ID, err)
+	if err := cfg.db.FailVideo(video.ID, failReason); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// transcodeProfileFor returns the profile a user's uploads get when they
+// don't name one: their saved preference, or the server default if they
+// have none or it's no longer configured.
+func (cfg *apiConfig) transcodeProfileFor(userID uuid.UUID) string {
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		log.Printf("Couldn't get settings for user %s: %v", userID, err)
+		return cfg.defaultTranscodeProfile
+	}
+	if settings.TranscodeProfile == nil {
+		return cfg.defaultTranscodeProfile
+	}
+	if _, ok := cfg.transcodeProfiles[*settings.TranscodeProfile]; !ok {
+		return cfg.defaultTranscodeProfile
+	}
+	return *settings.TranscodeProfile
+}
+
+// Get or replace the caller's preferences. PUT takes the full settings
+// object; a null transcode_profile goes back to the server default.
+func (cfg *apiConfig) handlerSettings(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TranscodeProfile *string `json:"transcode_profile"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var params parameters
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		if params.TranscodeProfile != nil && strings.TrimSpace(*params.TranscodeProfile) == "" {
+			params.TranscodeProfile = nil
+		}
+		if params.TranscodeProfile != nil {
+			if _, ok := cfg.transcodeProfiles[*params.TranscodeProfile]; !ok {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown transcode profile %q", *params.TranscodeProfile), nil)
+				return
+			}
+		}
+		err := cfg.db.SetUserSettings(userID, database.UserSettings{TranscodeProfile: params.TranscodeProfile})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't save settings", err)
+			return
+		}
+	}
+
+	settings, err := cfg.db.GetUserSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get settings", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, settings)
+}
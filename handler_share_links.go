@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// shareLinkPasswordHeader carries the password for a protected share link,
+// keeping it out of URLs and access logs.
+const shareLinkPasswordHeader = "X-Share-Password"
+
+type shareLinkResponse struct {
+	database.ShareLink
+	URL         string `json:"url"`
+	HasPassword bool   `json:"has_password"`
+}
+
+func (cfg *apiConfig) shareLinkResponse(link database.ShareLink) shareLinkResponse {
+	return shareLinkResponse{
+		ShareLink:   link,
+		URL:         fmt.Sprintf("http://localhost:%s/share/%s", cfg.port, link.Token),
+		HasPassword: link.PasswordHash != nil,
+	}
+}
+
+// Create a link that lets anyone holding it watch the video without an
+// account. The password and expiry (a duration such as "72h") are optional.
+func (cfg *apiConfig) handlerShareLinkCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password  string `json:"password"`
+		ExpiresIn string `json:"expires_in"`
+	}
+
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	var expiresAt *time.Time
+	if params.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(params.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			respondWithError(w, http.StatusBadRequest, "expires_in must be a positive duration such as \"72h\"", err)
+			return
+		}
+		at := time.Now().UTC().Add(ttl)
+		expiresAt = &at
+	}
+	var passwordHash *string
+	if params.Password != "" {
+		hash, err := auth.HashPassword(params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+		passwordHash = &hash
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link token", err)
+		return
+	}
+	link, err := cfg.db.CreateShareLink(database.CreateShareLinkParams{
+		Token:        token,
+		VideoID:      video.ID,
+		ExpiresAt:    expiresAt,
+		PasswordHash: passwordHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share link", err)
+		return
+	}
+	cfg.audit.record(&userID, video.ID, auditActionShareLink)
+
+	respondWithJSON(w, http.StatusCreated, cfg.shareLinkResponse(link))
+}
+
+// List a video's share links
+func (cfg *apiConfig) handlerShareLinksList(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	links, err := cfg.db.GetShareLinks(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share links", err)
+		return
+	}
+
+	resp := make([]shareLinkResponse, 0, len(links))
+	for _, link := range links {
+		resp = append(resp, cfg.shareLinkResponse(link))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// Revoke a share link
+func (cfg *apiConfig) handlerShareLinkRevoke(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := cfg.db.DeleteShareLink(video.ID, r.PathValue("token"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share link", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	cfg.audit.record(&userID, video.ID, auditActionRevokeShareLink)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Open a share link: no account needed, but a protected link needs its
+// password in the X-Share-Password header. Returns what a player needs to
+// show the video.
+func (cfg *apiConfig) handlerShareLinkOpen(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		VideoID         uuid.UUID  `json:"video_id"`
+		Title           string     `json:"title"`
+		Description     string     `json:"description"`
+		VideoURL        string     `json:"video_url"`
+		ThumbnailURL    *string    `json:"thumbnail_url"`
+		DurationSeconds float64    `json:"duration_seconds"`
+		ExpiresAt       *time.Time `json:"expires_at"`
+	}
+
+	link, err := cfg.db.GetShareLink(r.PathValue("token"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share link", err)
+		return
+	}
+	if link.Token == "" {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Share link has expired", nil)
+		return
+	}
+	if link.PasswordHash != nil {
+		password := r.Header.Get(shareLinkPasswordHeader)
+		if password == "" {
+			respondWithError(w, http.StatusUnauthorized, "Share link requires a password", nil)
+			return
+		}
+		if err := auth.CheckPasswordHash(password, *link.PasswordHash); err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Incorrect password", nil)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(link.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Share link not found", nil)
+		return
+	}
+	video = cfg.prepareVideo(video)
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		VideoID:         video.ID,
+		Title:           video.Title,
+		Description:     video.Description,
+		VideoURL:        *video.VideoURL,
+		ThumbnailURL:    video.ThumbnailURL,
+		DurationSeconds: video.DurationSeconds,
+		ExpiresAt:       link.ExpiresAt,
+	})
+}
@@ -1,19 +1,63 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"image"
 	"io"
+	"log"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"github.com/google/uuid"
 	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
 )
 
+// minThumbnailSide is the smallest an uploaded thumbnail's shorter side may be
+const minThumbnailSide = 320
+
+// maxThumbnailUploadBytes caps a thumbnail upload request.
+const maxThumbnailUploadBytes = 20 << 20 // 20MB
+
+// maxThumbnailPixels caps a thumbnail's width × height. Decoding takes at
+// least 4 bytes a pixel, and a small, well compressed file can claim huge
+// dimensions.
+const maxThumbnailPixels = 25_000_000
+
+// decodeThumbnail decodes a PNG or JPEG, refusing one over
+// maxThumbnailPixels from its header before any pixels are allocated.
+func decodeThumbnail(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode image: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxThumbnailPixels {
+		return nil, fmt.Errorf("image is %dx%d, over %d pixels", config.Width, config.Height, maxThumbnailPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode image: %w", err)
+	}
+	return img, nil
+}
+
+// thumbnailSize reads a PNG or JPEG's size from its header.
+func thumbnailSize(data []byte) (database.ThumbnailSize, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return database.ThumbnailSize{}, fmt.Errorf("couldn't decode image: %w", err)
+	}
+	return database.ThumbnailSize{
+		Width:  config.Width,
+		Height: config.Height,
+		Aspect: aspectBucket(config.Width, config.Height),
+	}, nil
+}
+
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -35,8 +79,14 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 
 	// Parse form
+	r.Body = http.MaxBytesReader(w, r.Body, maxThumbnailUploadBytes)
 	const maxMemory = 10 << 20 // 10MB
 	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", err)
+			return
+		}
 		respondWithError(w, http.StatusBadRequest, "Failed to parse form", err)
 		return
 	}
@@ -82,6 +132,74 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		ext = ".jpg"
 	}
 
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to read thumbnail", err)
+		return
+	}
+	// The Content-Type is only the client's word for it; check the bytes
+	if sniffed := http.DetectContentType(data); sniffed != mediaType {
+		respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Thumbnail content is %s, not the %s it was sent as", sniffed, mediaType), nil)
+		return
+	}
+	if cfg.thumbnailStripICC {
+		// Normalise colour by dropping any embedded ICC profile
+		data, err = stripICCProfile(data, mediaType)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid thumbnail image", err)
+			return
+		}
+	}
+
+	// Measure the original, which is always decodable PNG or JPEG
+	size, err := thumbnailSize(data)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid thumbnail image", err)
+		return
+	}
+	if min(size.Width, size.Height) < minThumbnailSide {
+		respondWithError(w, http.StatusBadRequest,
+			fmt.Sprintf("Thumbnail must be at least %dpx on its shorter side", minThumbnailSide), nil)
+		return
+	}
+	if int64(size.Width)*int64(size.Height) > maxThumbnailPixels {
+		respondWithError(w, http.StatusUnprocessableEntity,
+			fmt.Sprintf("Thumbnail must be at most %d pixels", maxThumbnailPixels), nil)
+		return
+	}
+
+	// Shrink oversized images before anything else uses them
+	if cfg.thumbnailMaxEdge > 0 && max(size.Width, size.Height) > cfg.thumbnailMaxEdge {
+		data, err = downscaleThumbnail(data, mediaType, cfg.thumbnailMaxEdge, cfg.thumbnailQuality)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid thumbnail image", err)
+			return
+		}
+		if size, err = thumbnailSize(data); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't read resized thumbnail", err)
+			return
+		}
+	}
+
+	// Hash it too; a missing placeholder isn't worth failing the upload over
+	var blurHash *string
+	if hash, err := thumbnailBlurHash(data); err != nil {
+		log.Printf("Couldn't compute blurhash for video %s: %v", videoID, err)
+	} else {
+		blurHash = &hash
+	}
+
+	if cfg.thumbnailFormat != nil {
+		// Store every thumbnail in the one configured format
+		data, err = cfg.convertThumbnailData(r.Context(), data)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid thumbnail image", err)
+			return
+		}
+		mediaType, ext = cfg.thumbnailFormat.mediaType, cfg.thumbnailFormat.ext
+	}
+
 	// Generate random filename
 	randomBytes := make([]byte, 32)
 	_, err = rand.Read(randomBytes)
@@ -91,32 +209,32 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	randomName := base64.RawURLEncoding.EncodeToString(randomBytes)
 	fileName := randomName + ext
-	filePath := filepath.Join(cfg.assetsRoot, fileName)
 
 	// Save file
-	outFile, err := os.Create(filePath)
+	url, err := cfg.storeThumbnail(r.Context(), fileName, bytes.NewReader(data), mediaType)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create file", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file", err)
 		return
 	}
-	defer outFile.Close()
 
-	_, err = io.Copy(outFile, file)
+	// Update only the thumbnail so concurrent edits to the record aren't clobbered
+	err = cfg.db.SetThumbnailURL(videoID, url, blurHash, &size)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save file", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video", err)
 		return
 	}
+	cfg.audit.record(&userID, videoID, auditActionUploadThumbnail)
 
-	// Update ThumbnailURL with new unique path
-	url := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fileName)
-	video.ThumbnailURL = &url
-
-	// Save to DB
-	err = cfg.db.UpdateVideo(video)
+	video, err = cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update video", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to get video", err)
+		return
+	}
+	// Deleted while the thumbnail was being stored
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+// Issue a short-lived ticket for uploading a video's file, after checking
+// everything that can be checked without the file. Send it back in the
+// X-Upload-Ticket header of the upload; with UPLOAD_TICKET_REQUIRED set,
+// uploads without one are refused before the body is read.
+func (cfg *apiConfig) handlerUploadTicket(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Ticket    string    `json:"ticket"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+		return
+	}
+	if video.Status.Busy() {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(cfg.uploadTicketTTL)
+	ticket, err := auth.MakeUploadTicket(userID, videoID, cfg.jwtSecret, cfg.uploadTicketTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't issue upload ticket", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// checkUploadTicket verifies the request's upload ticket was issued to
+// userID for videoID. Without UPLOAD_TICKET_REQUIRED a missing ticket is
+// allowed, but one that's present must still be valid.
+func (cfg *apiConfig) checkUploadTicket(r *http.Request, userID, videoID uuid.UUID) (int, string, error) {
+	ticket := r.Header.Get(auth.UploadTicketHeader)
+	if ticket == "" {
+		if cfg.uploadTicketRequired {
+			return http.StatusForbidden, "Upload ticket is required", nil
+		}
+		return 0, "", nil
+	}
+	ticketUser, ticketVideo, err := auth.ValidateUploadTicket(ticket, cfg.jwtSecret)
+	if err != nil {
+		return http.StatusForbidden, "Invalid upload ticket", err
+	}
+	if ticketUser != userID || ticketVideo != videoID {
+		return http.StatusForbidden, "Upload ticket is for a different video", nil
+	}
+	return 0, "", nil
+}
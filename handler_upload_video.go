@@ -1,120 +1,516 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
+	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/google/uuid"
 	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
 )
 
-type ffprobeOutput struct {
-	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"streams"`
+// getVideoAspectRatio probes a local file and returns "16:9", "9:16", or "other"
+func getVideoAspectRatio(ctx context.Context, filePath string) (string, error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	if len(probe.Streams) == 0 {
+		return "other", nil
+	}
+
+	return aspectBucket(probe.Streams[0].Width, probe.Streams[0].Height), nil
 }
 
-// getVideoAspectRatio runs ffprobe on a local file and returns "16:9", "9:16", or "other"
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe",
-		"-v", "error",
-		"-print_format", "json",
-		"-show_streams",
-		filePath,
-	)
+// aspectBucket groups a size into "16:9", "9:16" or "other", allowing for
+// the rounding of real-world sizes.
+func aspectBucket(width, height int) string {
+	if width == 0 || height == 0 {
+		return "other"
+	}
+	ratio := float64(width) / float64(height)
+	if ratio > 1.7 && ratio < 1.8 {
+		return "16:9"
+	} else if ratio > 0.55 && ratio < 0.6 {
+		return "9:16"
+	}
+	return "other"
+}
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
+// getVideoResolution probes a local file and returns the size of its first
+// video stream
+func getVideoResolution(ctx context.Context, filePath string) (width, height int, err error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	// Audio and data streams have no dimensions
+	for _, stream := range probe.Streams {
+		if stream.Width > 0 && stream.Height > 0 {
+			return stream.Width, stream.Height, nil
+		}
+	}
+	return 0, 0, errors.New("no video stream")
+}
+
+// audioPolicy decides whether uploads must, or must not, have sound.
+type audioPolicy string
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffprobe failed: %w", err)
+const (
+	audioPolicyAny       audioPolicy = "any"
+	audioPolicyRequired  audioPolicy = "required"
+	audioPolicyForbidden audioPolicy = "forbidden"
+)
+
+func parseAudioPolicy(raw string) (audioPolicy, error) {
+	switch audioPolicy(raw) {
+	case "":
+		return audioPolicyAny, nil
+	case audioPolicyAny, audioPolicyRequired, audioPolicyForbidden:
+		return audioPolicy(raw), nil
 	}
+	return "", fmt.Errorf("must be %q, %q or %q", audioPolicyAny, audioPolicyRequired, audioPolicyForbidden)
+}
 
-	var probe ffprobeOutput
-	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
-		return "", fmt.Errorf("unmarshal failed: %w", err)
+// hasAudioStream probes a local file and reports whether it has sound
+func hasAudioStream(ctx context.Context, filePath string) (bool, error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return false, err
 	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "audio" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	if len(probe.Streams) == 0 {
-		return "other", nil
+// checkAudio enforces AUDIO_POLICY.
+func (cfg *apiConfig) checkAudio(hasAudio bool) error {
+	switch {
+	case cfg.audioPolicy == audioPolicyRequired && !hasAudio:
+		return errors.New("video has no audio track; one is required")
+	case cfg.audioPolicy == audioPolicyForbidden && hasAudio:
+		return errors.New("video has an audio track; only silent videos are accepted")
 	}
+	return nil
+}
 
-	width := probe.Streams[0].Width
-	height := probe.Streams[0].Height
-	if width == 0 || height == 0 {
-		return "other", nil
+// checkResolution enforces VIDEO_MIN_RESOLUTION and VIDEO_MAX_RESOLUTION,
+// measured on the shorter side so portrait videos compare like landscape
+// ones ("480p" is 854x480 or 480x854).
+func (cfg *apiConfig) checkResolution(width, height int) error {
+	short := min(width, height)
+	if cfg.minResolution > 0 && short < cfg.minResolution {
+		return fmt.Errorf("video is %dx%d; the minimum is %dp", width, height, cfg.minResolution)
 	}
+	if cfg.maxResolution > 0 && short > cfg.maxResolution {
+		return fmt.Errorf("video is %dx%d; the maximum is %dp", width, height, cfg.maxResolution)
+	}
+	return nil
+}
 
-	ratio := float64(width) / float64(height)
-	if ratio > 1.7 && ratio < 1.8 {
-		return "16:9", nil
-	} else if ratio > 0.55 && ratio < 0.6 {
-		return "9:16", nil
+// getVideoDuration probes a local file and returns its duration in seconds
+func getVideoDuration(ctx context.Context, filePath string) (float64, error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", probe.Format.Duration, err)
 	}
-	return "other", nil
+	return duration, nil
 }
 
-// processVideoForFastStart tries remux first (dropping data streams), then re-encode if needed
-func processVideoForFastStart(filePath string) (string, error) {
-	outputPath := filePath + ".faststart.mp4"
+// errInsufficientDisk means the temp filesystem can't hold processing output.
+var errInsufficientDisk = errors.New("not enough free disk space")
 
-	// Attempt 1: remux (copy video/audio, drop data streams like tmcd)
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", filePath,
-		"-map", "0:v",
-		"-map", "0:a?",
-		"-c", "copy",
-		"-movflags", "faststart",
-		outputPath,
-	)
+// checkOutput makes sure ffmpeg actually wrote something. It can exit 0
+// having written nothing, or an empty file, when the disk fills mid-write.
+func checkOutput(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("ffmpeg reported success but wrote no output: %w", err)
+	}
+	if info.Size() == 0 {
+		return errors.New("ffmpeg reported success but wrote an empty file")
+	}
+	return nil
+}
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+// checkDiskFree fails with errInsufficientDisk unless the filesystem holding
+// path has room for need more bytes. Platforms that can't report free space
+// aren't checked.
+func checkDiskFree(path string, need int64) error {
+	free, err := diskFree(path)
+	if err != nil {
+		return nil
+	}
+	if free < uint64(need) {
+		return fmt.Errorf("%w: need %d bytes, have %d", errInsufficientDisk, need, free)
+	}
+	return nil
+}
 
-	if err := cmd.Run(); err == nil {
-		return outputPath, nil
-	} else {
-		fmt.Printf("ffmpeg remux failed, retrying with re-encode: %s\n", stderr.String())
+// processVideoForFastStart tries remux first (dropping data streams), then re-encode if needed.
+// ffmpeg is killed if ctx is cancelled, its deadline passes, or it stalls for longer than stall.
+// A re-encode that comes out more than maxGrowth (a fraction, e.g. 0.1) larger than the
+// original is thrown away and the original kept instead, since it was already well compressed.
+// Every output is checked to be non-empty before it's used.
+// A non-empty frameRate marks a variable frame rate source: a remux would keep its
+// timing and drift out of sync, so it's always re-encoded at that constant rate and
+// the re-encode is kept however much it grows. convert does the same for sources that
+// aren't mp4, and re-encodes their audio as AAC too.
+// A file that's already fast-start with nothing to drop is returned as filePath itself.
+func processVideoForFastStart(ctx context.Context, filePath string, stall time.Duration, maxGrowth float64, frameRate string, convert bool) (string, error) {
+	mustReencode := frameRate != "" || convert
+	if !mustReencode && alreadyFastStart(ctx, filePath) {
+		return filePath, nil
+	}
+	outputPath := filePath + ".faststart.mp4"
+
+	// Each attempt writes about one copy of the input, plus any growth
+	srcInfo, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if err := checkDiskFree(filepath.Dir(filePath), int64(float64(srcInfo.Size())*(1+maxGrowth))); err != nil {
+		return "", err
+	}
+
+	// Attempt 1: remux (copy video/audio, drop data streams like tmcd)
+	if !mustReencode {
+		err = runFFmpeg(ctx, stall,
+			"-i", filePath,
+			"-map", "0:v",
+			"-map", "0:a?",
+			"-c", "copy",
+			"-movflags", "faststart",
+			outputPath,
+		)
+		if err == nil {
+			err = checkOutput(outputPath)
+			if err == nil {
+				return outputPath, nil
+			}
+		} else if ctx.Err() != nil || errors.Is(err, errFFmpegStalled) {
+			os.Remove(outputPath)
+			return "", fmt.Errorf("ffmpeg remux aborted: %w", err)
+		}
+		os.Remove(outputPath)
+		fmt.Printf("ffmpeg remux failed, retrying with re-encode: %v\n", err)
 	}
 
 	// Fallback: re-encode (square pixels), copy audio
 	outputPathReencode := filePath + ".reencode.mp4"
-	cmd = exec.Command(
-		"ffmpeg",
+	args := []string{
 		"-i", filePath,
 		"-vf", "setsar=1",
 		"-c:v", "libx264", "-crf", "18", "-preset", "veryfast",
-		"-c:a", "copy",
+	}
+	if frameRate != "" {
+		args = append(args, "-vsync", "cfr", "-r", frameRate)
+	}
+	if convert {
+		args = append(args, "-c:a", "aac")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args,
 		"-movflags", "faststart",
 		outputPathReencode,
 	)
+	err = runFFmpeg(ctx, stall, args...)
+	if err == nil {
+		err = checkOutput(outputPathReencode)
+	}
+	if err != nil {
+		os.Remove(outputPathReencode)
+		return "", fmt.Errorf("ffmpeg re-encode failed: %w", err)
+	}
+
+	outInfo, outErr := os.Stat(outputPathReencode)
+	if outErr != nil || mustReencode || float64(outInfo.Size()) <= float64(srcInfo.Size())*(1+maxGrowth) {
+		return outputPathReencode, nil
+	}
+	log.Printf("Re-encode of %s grew it from %d to %d bytes; keeping the original",
+		filepath.Base(filePath), srcInfo.Size(), outInfo.Size())
+	os.Remove(outputPathReencode)
+
+	// Keep every stream this time, so only the moov atom moves; if even
+	// that fails the original is used exactly as uploaded
+	err = runFFmpeg(ctx, stall,
+		"-i", filePath,
+		"-map", "0",
+		"-c", "copy",
+		"-movflags", "faststart",
+		outputPath,
+	)
+	if err == nil {
+		if err = checkOutput(outputPath); err == nil {
+			return outputPath, nil
+		}
+	} else if ctx.Err() != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg remux aborted: %w", err)
+	}
+	os.Remove(outputPath)
+	if err := copyFile(filePath, outputPath); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// copyFile copies src to a new file at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// transcodeTimeout is the time allowed for processing an input of the given
+// size: the base timeout plus, optionally, a per-GB allowance.
+func (cfg *apiConfig) transcodeTimeout(inputSize int64) time.Duration {
+	timeout := cfg.transcodeTimeoutBase
+	if cfg.transcodeTimeoutPerGB > 0 && inputSize > 0 {
+		timeout += time.Duration(float64(cfg.transcodeTimeoutPerGB) * float64(inputSize) / (1 << 30))
+	}
+	return timeout
+}
+
+// mediaTypeExtensions gives the extension stored objects of each accepted
+// upload type get when the upload's filename doesn't have one.
+// Any of them can be allowed with ALLOWED_VIDEO_TYPES.
+var mediaTypeExtensions = map[string]string{
+	"video/mp4":        ".mp4",
+	"video/quicktime":  ".mov",
+	"video/webm":       ".webm",
+	"video/x-matroska": ".mkv",
+	"video/x-msvideo":  ".avi",
+}
+
+// mediaTypeFormats gives the ffprobe demuxer name files of each accepted
+// upload type are read as. MP4 and QuickTime share a demuxer, as do WebM and
+// Matroska, so within those pairs either name matches.
+var mediaTypeFormats = map[string]string{
+	"video/mp4":        "mp4",
+	"video/quicktime":  "mov",
+	"video/webm":       "webm",
+	"video/x-matroska": "matroska",
+	"video/x-msvideo":  "avi",
+}
+
+// checkVideoContent makes sure ffprobe reads a file as the media type it was
+// sent as, with a video stream it can decode, since the Content-Type is only
+// the client's word for it.
+func checkVideoContent(ctx context.Context, filePath, mediaType string) error {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return errors.New("isn't a readable video")
+	}
+	matches := false
+	for _, name := range strings.Split(probe.Format.FormatName, ",") {
+		if name == mediaTypeFormats[mediaType] {
+			matches = true
+		}
+	}
+	if !matches {
+		return fmt.Errorf("content isn't %s (read as %s)", mediaType, probe.Format.FormatName)
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType == "video" && stream.CodecName != "" {
+			return nil
+		}
+	}
+	return errors.New("has no decodable video stream")
+}
+
+// detectVideoType returns the allowed upload type ffprobe reads a file as,
+// for sources such as imports whose Content-Type isn't the file's own.
+// Where a demuxer covers two types the alphabetically first wins, so the
+// MP4 family is video/mp4 and Matroska is video/webm when both are allowed.
+func (cfg *apiConfig) detectVideoType(ctx context.Context, filePath string) (string, error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return "", errors.New("isn't a readable video")
+	}
+	names := strings.Split(probe.Format.FormatName, ",")
+	for _, mediaType := range cfg.videoTypes() {
+		if slices.Contains(names, mediaTypeFormats[mediaType]) {
+			return mediaType, nil
+		}
+	}
+	return "", fmt.Errorf("must be one of %s, not %s", strings.Join(cfg.videoTypes(), ", "), probe.Format.FormatName)
+}
+
+const defaultVideoTypes = "video/mp4,video/quicktime,video/webm"
+
+// parseVideoTypes reads a comma-separated list of the media types uploads
+// may have. Only types with a known extension can be allowed.
+func parseVideoTypes(spec string) (map[string]bool, error) {
+	types := map[string]bool{}
+	for _, raw := range strings.Split(spec, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(raw))
+		if mediaType == "" {
+			continue
+		}
+		if _, ok := mediaTypeExtensions[mediaType]; !ok {
+			return nil, fmt.Errorf("unsupported media type %q", mediaType)
+		}
+		types[mediaType] = true
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("at least one media type is required")
+	}
+	return types, nil
+}
+
+// videoTypes lists the media types uploads may have, sorted.
+func (cfg *apiConfig) videoTypes() []string {
+	types := make([]string, 0, len(cfg.allowedVideoTypes))
+	for mediaType := range cfg.allowedVideoTypes {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// uploadExtension returns the extension for an uploaded file's object key:
+// the filename's own, or failing that one derived from its media type, so
+// keys never end up without one.
+func uploadExtension(filename, mediaType string) string {
+	if ext := filepath.Ext(filename); ext != "" {
+		return ext
+	}
+	return mediaTypeExtensions[mediaType]
+}
+
+// statusClientClosedRequest is nginx's non-standard status for a client
+// that hung up before the request was complete. Nobody receives it; it's
+// for logs.
+const statusClientClosedRequest = 499
+
+var (
+	uploadsInterrupted = expvar.NewInt("uploads_interrupted")
+	uploadsSaveFailed  = expvar.NewInt("uploads_save_failed")
+	// uploadBytesSpooled counts bytes of uploaded videos written to temp
+	// files, which happens once per upload
+	uploadBytesSpooled = expvar.NewInt("upload_bytes_spooled")
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+	// ctx, if set, stops reads with its cause once it's done
+	ctx context.Context
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.ctx != nil && c.ctx.Err() != nil {
+		return 0, context.Cause(c.ctx)
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// restoreStatus puts back the status a video had before an upload that
+// didn't get as far as processing, keeping why it failed if it had. It's
+// left alone if something else, such as a cancel, has changed it since.
+func (cfg *apiConfig) restoreStatus(video database.Video) {
+	if _, err := cfg.db.SwapStatus(video.ID, database.VideoStatusUploading, video.Status); err != nil {
+		log.Printf("Couldn't restore status of video %s: %v", video.ID, err)
+	}
+}
+
+// runUpload processes a received upload in the background, recording the
+// outcome on both the job and the video, and reporting it to callbackURL if
+// there is one. It removes the uploaded file when done.
+func (cfg *apiConfig) runUpload(j *job, req ingestRequest, callbackURL *url.URL) {
+	defer os.Remove(req.srcPath)
+	defer cfg.recoverJob(j, req.video.ID)
+	ctx, untrack := cfg.jobs.trackUpload(context.Background(), req.video.ID)
+	defer untrack()
 
-	stderr.Reset()
-	cmd.Stderr = &stderr
+	req.stage = j.setStage
+	req.queuePosition = func(position int) {
+		j.update(func(s *jobState) { s.QueuePosition = position })
+	}
+	failReason := "upload failed"
+	err := cfg.ingestVideo(ctx, req)
+	var ingestErr *ingestError
+	switch {
+	case err == nil:
+	case context.Cause(ctx) == errUploadCancelled:
+		failReason = "upload cancelled"
+	case errors.As(err, &ingestErr):
+		failReason = ingestErr.reason
+		err = errors.New(ingestErr.message)
+	}
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("ffmpeg re-encode failed: %v, details: %s", err, stderr.String())
+	j.finish(err)
+	if err != nil {
+		log.Printf("Processing upload of video %s failed: %v", req.video.ID, err)
+		if err := cfg.db.FailVideo(req.video.ID, failReason); err != nil {
+			log.Printf("Couldn't mark video %s as failed: %v", req.video.ID, err)
+		}
+	}
+	if callbackURL != nil {
+		cfg.sendUploadCallback(callbackURL, req.video.ID)
 	}
+}
+
+// maxVideoUploadBytes is the largest video file accepted, however it's sent
+const maxVideoUploadBytes = 1 << 30
 
-	return outputPathReencode, nil
+// uploadInterrupted reports whether err means the client stopped sending
+// the body part way through, rather than something failing on our side.
+func uploadInterrupted(r *http.Request, err error) bool {
+	return r.Context().Err() != nil || errors.Is(err, io.ErrUnexpectedEOF)
 }
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	// Limit upload size to 1 GB
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
+	if cfg.uploadRequireLength && r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "Content-Length is required", nil)
+		return
+	}
+
+	uploadsActive.Add(1)
+	defer uploadsActive.Add(-1)
+
+	body := &countingReader{r: r.Body}
+	r.Body = http.MaxBytesReader(w, io.NopCloser(body), maxVideoUploadBytes)
 
 	// Parse videoID
 	videoIDString := r.PathValue("videoID")
@@ -148,109 +544,586 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 			fmt.Errorf("user %s does not own video", userID))
 		return
 	}
-
-	// Parse uploaded file
-	const maxMemory = 10 << 20
-	if err := r.ParseMultipartForm(maxMemory); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Failed to parse form", err)
+	if code, msg, err := cfg.checkUploadTicket(r, userID, videoID); code != 0 {
+		respondWithError(w, code, msg, err)
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("video")
+	// From here on the owner can cancel the upload with DELETE .../upload
+	ctx, untrack := cfg.jobs.trackUpload(r.Context(), videoID)
+	defer untrack()
+	body.ctx = ctx
+
+	// The video reads as uploading while its body arrives, and goes back to
+	// how it was if the upload never gets as far as processing. Claiming it
+	// only from the status just read keeps two requests from both starting.
+	if video.Status.Busy() {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+	claimed, err := cfg.db.SwapStatus(videoID, video.Status, database.VideoStatusUploading)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Missing video file", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video status", err)
 		return
 	}
-	defer file.Close()
+	if !claimed {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+	receiving := true
+	defer func() {
+		if receiving {
+			cfg.restoreStatus(video)
+		}
+	}()
 
-	// Validate MIME type
-	contentType := fileHeader.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
+	// Read the form, writing the video part to a temp file. It's removed
+	// when we're done unless a background job takes it over.
+	upload, err := readUploadForm(r)
+	handedOff := false
+	defer func() {
+		if upload != nil && !handedOff {
+			os.Remove(upload.path)
+		}
+	}()
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondWithValidationErrors(w, validationErrors{{"video", "must be at most 1GB"}})
+			return
+		}
+		if errors.Is(err, errSaveUpload) {
+			uploadsSaveFailed.Add(1)
+			respondWithError(w, http.StatusInternalServerError, "Failed to save temp file", err)
+			return
+		}
+		if context.Cause(ctx) == errUploadCancelled {
+			respondWithError(w, http.StatusConflict, "Upload was cancelled", err)
+			return
+		}
+		if uploadInterrupted(r, err) {
+			uploadsInterrupted.Add(1)
+			respondWithError(w, statusClientClosedRequest, "Upload interrupted",
+				fmt.Errorf("client sent %d of %d bytes for video %s: %w", body.n, r.ContentLength, videoID, err))
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Failed to parse form", err)
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Unsupported video type", nil)
+	// The form can parse cleanly from a body cut short at a part boundary
+	if r.ContentLength > 0 && body.n < r.ContentLength {
+		uploadsInterrupted.Add(1)
+		respondWithError(w, statusClientClosedRequest, "Upload interrupted",
+			fmt.Errorf("client sent %d of %d bytes for video %s", body.n, r.ContentLength, videoID))
 		return
 	}
 
-	// Save to temp file
-	tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create temp file", err)
+	// Check every field before giving up so the client sees all the problems
+	var invalid validationErrors
+
+	// Instead of a file, the form may name a URL for the server to fetch
+	var sourceURL *url.URL
+	if raw := r.FormValue("source_url"); raw != "" {
+		sourceURL, err = url.Parse(raw)
+		if err != nil {
+			invalid.add("source_url", "is not a valid URL")
+		} else if err := cfg.imports.checkImportURL(sourceURL); err != nil {
+			invalid.add("source_url", "%s", err.Error())
+		}
+	}
+
+	// Optionally tell the client's server how processing ended
+	var callbackURL *url.URL
+	if raw := r.FormValue("callback_url"); raw != "" {
+		callbackURL, err = url.Parse(raw)
+		if err != nil {
+			invalid.add("callback_url", "is not a valid URL")
+		} else if err := checkCallbackURL(callbackURL); err != nil {
+			invalid.add("callback_url", "%s", err.Error())
+		}
+	}
+
+	var mediaType string
+	if upload == nil {
+		if sourceURL == nil {
+			invalid.add("video", "is required unless source_url is given")
+		}
+	} else if sourceURL != nil {
+		invalid.add("video", "can't be sent along with source_url")
+	} else {
+		// Validate MIME type
+		mediaType, _, err = mime.ParseMediaType(upload.contentType)
+		if err != nil {
+			invalid.add("video", "has an invalid Content-Type")
+		} else if !cfg.allowedVideoTypes[mediaType] {
+			invalid.add("video", "must be one of %s, not %s", strings.Join(cfg.videoTypes(), ", "), mediaType)
+		}
+	}
+
+	// Pick the transcode profile, falling back to the user's preference and
+	// then the server default
+	profileName := r.FormValue("profile")
+	if profileName == "" {
+		profileName = cfg.transcodeProfileFor(userID)
+	}
+	profile, ok := cfg.transcodeProfiles[profileName]
+	if !ok {
+		invalid.add("profile", "unknown transcode profile %q", profileName)
+	}
+
+	// Optionally keep only a clip of the upload; whether it fits the video
+	// can only be checked once the file has been probed
+	trim, err := parseTrimRange(r.FormValue("start"), r.FormValue("end"))
+	var trimErr fieldError
+	if errors.As(err, &trimErr) {
+		invalid = append(invalid, trimErr)
+	}
+
+	// Clients whose files are already web-ready can have them stored as sent
+	skipProcessing := false
+	if raw := r.FormValue("skip_processing"); raw != "" {
+		skipProcessing, err = strconv.ParseBool(raw)
+		if err != nil {
+			invalid.add("skip_processing", "must be true or false")
+		} else if skipProcessing {
+			allowed, err := cfg.canSkipProcessing(userID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check account", err)
+				return
+			}
+			switch {
+			case !allowed:
+				invalid.add("skip_processing", "isn't allowed for this account")
+			case sourceURL != nil:
+				invalid.add("skip_processing", "can't be used with source_url")
+			case trim != nil:
+				invalid.add("skip_processing", "can't be used with start or end")
+			case mediaType != "" && mediaType != "video/mp4":
+				invalid.add("skip_processing", "only works for video/mp4 uploads, not %s", mediaType)
+			}
+		}
+	}
+
+	if len(invalid) > 0 {
+		respondWithValidationErrors(w, invalid)
 		return
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	if _, err = io.Copy(tempFile, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to save temp file", err)
+	// A fetched source goes through the same path as imports: the download
+	// and processing happen in the background and the client polls the job
+	if sourceURL != nil {
+		if err := cfg.db.SetStatus(videoID, database.VideoStatusProcessing); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to update video status", err)
+			return
+		}
+		receiving = false
+		j := cfg.jobs.start("import", userID, videoID)
+		go cfg.runImport(j, video, sourceURL, profile, trim, cfg.uploadTarget(r), callbackURL)
+		respondWithJSON(w, http.StatusAccepted, j.snapshot())
 		return
 	}
 
-	// Process video for fast start
-	processedPath, err := processVideoForFastStart(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to process video for fast start", err)
+	// Mark as processing; any failure from here on leaves the video failed
+	if err := cfg.db.SetStatus(videoID, database.VideoStatusProcessing); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video status", err)
 		return
 	}
-	defer os.Remove(processedPath)
+	receiving = false
+	// queued is set once a background job owns the rest of the upload
+	uploaded, queued := false, false
+	failReason := "upload failed"
+	defer func() {
+		if queued {
+			return
+		}
+		if !uploaded {
+			if err := cfg.db.FailVideo(videoID, failReason); err != nil {
+				log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+			}
+		}
+		if callbackURL != nil {
+			go cfg.sendUploadCallback(callbackURL, videoID)
+		}
+	}()
 
-	processedFile, err := os.Open(processedPath)
+	// With a callback to report to, processing carries on if the client
+	// disconnects; an explicit cancel still stops it
+	if callbackURL != nil {
+		untrack()
+		ctx, untrack = cfg.jobs.trackUpload(context.WithoutCancel(r.Context()), videoID)
+		defer untrack()
+	}
+
+	// ffmpeg reads the file readUploadForm wrote in place
+	req := ingestRequest{
+		video:       video,
+		userID:      userID,
+		srcPath:     upload.path,
+		contentType: mediaType,
+		profile:     profile,
+		trim:        trim,
+		target:      cfg.uploadTarget(r),
+
+		skipProcessing: skipProcessing,
+	}
+
+	// The file is all that's needed from the request, so processing can go
+	// on after the response; the client polls the job or the video's status
+	if cfg.uploadAsync {
+		j := cfg.jobs.start("upload", userID, videoID)
+		queued, handedOff = true, true
+		go cfg.runUpload(j, req, callbackURL)
+		respondWithJSON(w, http.StatusAccepted, j.snapshot())
+		return
+	}
+
+	err = cfg.ingestVideo(ctx, req)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to open processed file", err)
+		var ingestErr *ingestError
+		switch {
+		case context.Cause(ctx) == errUploadCancelled:
+			failReason = "upload cancelled"
+			respondWithError(w, http.StatusConflict, "Upload was cancelled", err)
+		case callbackURL == nil && r.Context().Err() != nil:
+			// The client went away; there's nobody left to respond to
+			failReason = "upload cancelled"
+			log.Printf("Client cancelled upload of video %s during processing: %v", videoID, err)
+		case errors.As(err, &ingestErr):
+			failReason = ingestErr.reason
+			var invalid fieldError
+			if errors.As(ingestErr.err, &invalid) {
+				respondWithValidationErrors(w, validationErrors{invalid})
+				break
+			}
+			respondWithError(w, ingestErr.status, ingestErr.message, ingestErr.err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, "Failed to upload video", err)
+		}
 		return
 	}
-	defer processedFile.Close()
+	uploaded = true
 
-	// Determine aspect ratio (for folder prefix)
-	aspect, err := getVideoAspectRatio(processedPath)
+	video, err = cfg.db.GetVideo(videoID)
 	if err != nil {
-		aspect = "other"
+		respondWithError(w, http.StatusInternalServerError, "Failed to get video", err)
+		return
+	}
+	// Deleted while it was being processed
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
 	}
 
-	var prefix string
-	switch aspect {
-	case "16:9":
-		prefix = "landscape/"
-	case "9:16":
-		prefix = "portrait/"
+	// Return the DB record as-is (no signing needed anymore)
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
+}
+
+// Cancel a video's in-flight upload or import. The upload stops reading,
+// ffmpeg is killed and any multipart upload to S3 is aborted; the video is
+// left failed with reason "upload cancelled" (or still a draft if its body
+// hadn't finished arriving).
+func (cfg *apiConfig) handlerUploadCancel(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if !cfg.jobs.cancelUpload(video.ID) {
+		respondWithError(w, http.StatusNotFound, "No upload in progress for this video", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusAccepted, map[string]string{"status": "cancelling"})
+}
+
+// ingestRequest is a local video file waiting to be processed and stored.
+type ingestRequest struct {
+	video   database.Video // the record as it was before processing
+	userID  uuid.UUID
+	srcPath string
+	// contentType is the source's media type; empty has it detected from
+	// the file itself
+	contentType string
+	profile     transcodeProfile
+	trim        *trimRange
+	target      *storageTarget
+	// skipProcessing stores the file as sent, without remux or re-encode
+	skipProcessing bool
+	// stage, if set, is told as each step begins
+	stage func(name string)
+	// queuePosition, if set, is told the request's place in the transcode
+	// queue while it waits, and 0 once it gets a slot
+	queuePosition func(position int)
+}
+
+// ingestError is a failed ingest step. reason is recorded on the video and
+// message is safe to show the client.
+type ingestError struct {
+	status  int
+	reason  string
+	message string
+	err     error
+}
+
+func (e *ingestError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *ingestError) Unwrap() error { return e.err }
+
+// transcodeFailure describes a failed trim or transcode for the client.
+func transcodeFailure(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, errInsufficientDisk):
+		return &ingestError{http.StatusInsufficientStorage, "insufficient disk space", "Not enough disk space to process the video", err}
+	case errors.Is(err, errFFmpegStalled):
+		return &ingestError{http.StatusInternalServerError, "no progress / stalled", "Video processing stalled", err}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &ingestError{http.StatusInternalServerError, "processing timeout", "Video processing timed out", err}
 	default:
-		prefix = "other/"
+		return &ingestError{http.StatusInternalServerError, "processing failed", "Failed to process video", err}
 	}
+}
 
-	// Generate random filename
-	randomBytes := make([]byte, 32)
-	if _, err = rand.Read(randomBytes); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
-		return
+// ingestVideo trims and transcodes a local file, stores the result as the
+// video's file and marks the video ready. Marking the video failed when an
+// error comes back is left to the caller. Each run is written to the
+// video's processing log.
+func (cfg *apiConfig) ingestVideo(ctx context.Context, req ingestRequest) error {
+	// The source can wait a long time for a transcode slot; the reaper
+	// mustn't remove it, or the outputs made from it, meanwhile
+	release := cfg.jobs.holdFile(req.srcPath)
+	defer release()
+	ctx, closeLog := cfg.openProcessingLog(ctx, req.video.ID)
+	err := cfg.ingest(ctx, req)
+	closeLog(err)
+	return err
+}
+
+func (cfg *apiConfig) ingest(ctx context.Context, req ingestRequest) error {
+	stage := func(name string) {
+		logProcessing(ctx, "stage: %s", name)
+		if req.stage != nil {
+			req.stage(name)
+		}
+	}
+	videoID := req.video.ID
+
+	srcInfo, err := os.Stat(req.srcPath)
+	if err != nil {
+		return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to stat source file", err}
+	}
+	if req.contentType == "" {
+		req.contentType, err = cfg.detectVideoType(ctx, req.srcPath)
+		if err != nil {
+			return &ingestError{http.StatusBadRequest, "invalid video", "Video " + err.Error(), err}
+		}
+	}
+	if err := checkVideoContent(ctx, req.srcPath, req.contentType); err != nil {
+		return &ingestError{http.StatusBadRequest, "invalid video", "Video " + err.Error(), err}
+	}
+
+	if req.trim != nil {
+		duration, err := getVideoDuration(ctx, req.srcPath)
+		if err != nil {
+			return &ingestError{http.StatusBadRequest, "processing failed", "Couldn't read video duration", err}
+		}
+		if err := req.trim.validate(duration); err != nil {
+			return &ingestError{http.StatusUnprocessableEntity, "invalid trim range", err.Error(), err}
+		}
+	}
+
+	if cfg.minResolution > 0 || cfg.maxResolution > 0 {
+		width, height, err := getVideoResolution(ctx, req.srcPath)
+		if err != nil {
+			return &ingestError{http.StatusBadRequest, "processing failed", "Couldn't read video resolution", err}
+		}
+		if err := cfg.checkResolution(width, height); err != nil {
+			return &ingestError{http.StatusBadRequest, "invalid resolution", err.Error(), err}
+		}
+	}
+
+	if cfg.audioPolicy != audioPolicyAny {
+		hasAudio, err := hasAudioStream(ctx, req.srcPath)
+		if err != nil {
+			return &ingestError{http.StatusBadRequest, "processing failed", "Couldn't read video streams", err}
+		}
+		if err := cfg.checkAudio(hasAudio); err != nil {
+			return &ingestError{http.StatusBadRequest, "invalid audio", err.Error(), err}
+		}
+	}
+
+	if req.skipProcessing {
+		if err := checkMP4(ctx, req.srcPath); err != nil {
+			return &ingestError{http.StatusUnprocessableEntity, "invalid video", "Video " + err.Error(), fieldError{"video", err.Error()}}
+		}
+	}
+
+	// Wait for a transcode slot; higher tiers go first
+	tier := ""
+	if user, err := cfg.db.GetUser(req.userID); err != nil {
+		log.Printf("Couldn't look up tier for user %s: %v", req.userID, err)
+	} else if user != nil {
+		tier = user.Tier
+	}
+	stage("queued")
+	release, err := cfg.transcodeQueue.acquire(ctx, tier, req.queuePosition)
+	if err != nil {
+		return &ingestError{http.StatusServiceUnavailable, "processing failed", "Gave up waiting for a transcode slot", err}
 	}
-	randomName := base64.RawURLEncoding.EncodeToString(randomBytes)
-	key := prefix + randomName + filepath.Ext(fileHeader.Filename)
+	defer release()
 
-	// Upload to S3
-	_, err = cfg.s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &key,
-		Body:        processedFile,
-		ContentType: &mediaType,
+	// Trim and transcode with the chosen profile, bounded by the transcode timeout
+	stage("processing")
+	transcodeCtx, cancel := context.WithTimeout(ctx, cfg.transcodeTimeout(srcInfo.Size()))
+	defer cancel()
+	sourcePath := req.srcPath
+	if req.trim != nil {
+		trimmedPath, err := trimVideo(transcodeCtx, sourcePath, *req.trim, cfg.ffmpegStallTimeout)
+		if err != nil {
+			return transcodeFailure(transcodeCtx, err)
+		}
+		defer os.Remove(trimmedPath)
+		sourcePath = trimmedPath
+	}
+
+	// Variable frame rate sources drift out of sync when only remuxed, so
+	// they're re-encoded at a constant rate whatever the profile
+	// Other formats are always converted: remuxing them as-is would keep
+	// streams an mp4 can't hold
+	profile := req.profile
+	profile.convert = req.contentType != "video/mp4"
+	if !req.skipProcessing {
+		rate, err := constantFrameRate(ctx, sourcePath, cfg.vfrTargetFPS)
+		if err != nil {
+			log.Printf("Couldn't check frame rate of video %s: %v", videoID, err)
+		} else if rate != "" {
+			logProcessing(ctx, "variable frame rate source, re-encoding at %s fps", rate)
+			profile.frameRate = rate
+		}
+	}
+
+	// Fragmented output can be encoded straight into the bucket. That never
+	// writes the processed file, so the source stands in for it when probing
+	// and when making previews and DASH packages.
+	streamed := cfg.streamTranscodes && req.profile.Fragmented && !req.skipProcessing
+	processedPath := sourcePath
+	if !streamed && !req.skipProcessing {
+		processedPath, err = transcodeWithProfile(transcodeCtx, sourcePath, profile, cfg.ffmpegStallTimeout, cfg.reencodeMaxGrowth)
+		if err != nil {
+			return transcodeFailure(transcodeCtx, err)
+		}
+		if processedPath != sourcePath {
+			defer os.Remove(processedPath)
+		}
+	}
+
+	// Determine aspect ratio (for the orientation folder)
+	aspect, err := getVideoAspectRatio(ctx, processedPath)
+	if err != nil {
+		aspect = "other"
+	}
+
+	// Processed files are in the profile's container; skipped ones are
+	// stored as sent, and only MP4s may skip
+	container := outputContainers["mp4"]
+	if !req.skipProcessing {
+		container = req.profile.container()
+	}
+	ext, contentType := container.ext, container.contentType
+
+	key, err := cfg.objectKeyTemplate.build(objectKeyParams{
+		orientation: orientationFolder(aspect),
+		userID:      req.userID,
+		videoID:     videoID,
+		ext:         ext,
+		now:         time.Now(),
 	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to upload video to S3", err)
-		return
+		return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to generate random key", err}
+	}
+
+	// Upload to S3, in the bucket nearest the user when several are configured.
+	// A download-only video's replacement file is stored as an attachment.
+	stage("uploading")
+	target := req.target
+	disposition := contentDisposition(req.video.DownloadOnly)
+	var size int64
+	if streamed {
+		size, err = streamTranscode(transcodeCtx, sourcePath, profile, cfg.ffmpegStallTimeout, target, key, contentType, disposition)
+		if err != nil {
+			return transcodeFailure(transcodeCtx, err)
+		}
+	} else {
+		processedFile, err := os.Open(processedPath)
+		if err != nil {
+			return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to open processed file", err}
+		}
+		defer processedFile.Close()
+
+		processedInfo, err := processedFile.Stat()
+		if err != nil {
+			return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to stat processed file", err}
+		}
+		size = processedInfo.Size()
+
+		if err := target.putVideo(ctx, key, processedFile, contentType, disposition); err != nil {
+			return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to upload video to S3", err}
+		}
 	}
 
 	// Store a CloudFront URL (not presigned, not bucket,key)
-	// Expect cfg.s3CfDistribution to be something like: dxxxxxxx.cloudfront.net
-	cfURL := fmt.Sprintf("https://%s/%s", cfg.s3CfDistribution, key)
-	video.VideoURL = &cfURL
+	// Expect the distribution to be something like: dxxxxxxx.cloudfront.net
+	cfURL := target.objectURL(key)
+	if err := cfg.db.SetVideoURL(videoID, &cfURL, size); err != nil {
+		return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to update video record", err}
+	}
+	if err := cfg.db.SetContentType(videoID, contentType); err != nil {
+		log.Printf("Couldn't store content type of video %s: %v", videoID, err)
+	}
+	if err := cfg.db.SetAspectRatio(videoID, aspect); err != nil {
+		log.Printf("Couldn't store aspect ratio of video %s: %v", videoID, err)
+	}
+	if err := cfg.db.SetProcessingSkipped(videoID, req.skipProcessing); err != nil {
+		log.Printf("Couldn't record whether processing was skipped for video %s: %v", videoID, err)
+	}
+	// A streamed encode never touched disk, so its summary waits for the
+	// first request
+	if !streamed {
+		if _, err := cfg.storeTechInfo(ctx, videoID, processedPath); err != nil {
+			log.Printf("Couldn't record technical info of video %s: %v", videoID, err)
+		}
+	}
+	if duration, err := getVideoDuration(ctx, processedPath); err != nil {
+		log.Printf("Couldn't read duration of video %s: %v", videoID, err)
+	} else if err := cfg.db.SetDuration(videoID, duration); err != nil {
+		log.Printf("Couldn't store duration of video %s: %v", videoID, err)
+	}
+	if err := cfg.db.SetStatus(videoID, database.VideoStatusReady); err != nil {
+		return &ingestError{http.StatusInternalServerError, "upload failed", "Failed to update video status", err}
+	}
+	cfg.audit.record(&req.userID, videoID, auditActionUpload)
 
-	if err := cfg.db.UpdateVideo(video); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to update video record", err)
-		return
+	// Previews are a nice-to-have; failing to make one doesn't fail the upload.
+	// Any preview of a previous file is stale now either way.
+	switch cfg.preview.mode {
+	case previewModeUpload:
+		if _, err := cfg.createPreview(ctx, cfg.assetTarget(assetPreview, target), videoID, processedPath, seekModeFast); err != nil {
+			log.Printf("Couldn't generate preview for video %s: %v", videoID, err)
+		}
+	case previewModeLazy:
+		if err := cfg.db.SetPreviewURL(videoID, nil); err != nil {
+			log.Printf("Couldn't clear stale preview for video %s: %v", videoID, err)
+		}
 	}
 
-	// Return the DB record as-is (no signing needed anymore)
-	respondWithJSON(w, http.StatusOK, video)
+	cfg.refreshDASH(ctx, cfg.assetTarget(assetDASH, target), req.video, processedPath)
+
+	if err := cfg.db.AddUserStorageBytes(req.userID, size-req.video.SizeBytes); err != nil {
+		log.Printf("Couldn't update storage usage for user %s: %v", req.userID, err)
+	} else {
+		cfg.checkQuota(req.userID)
+	}
+	return nil
 }
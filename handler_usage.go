@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// Report the caller's storage footprint: totals, an estimate of the last
+// month's bandwidth from views, plus breakdowns by video status and by key
+// prefix. The storage counter is included alongside the
+// sum of video sizes so drift between the two is visible.
+func (cfg *apiConfig) handlerUsageDetailed(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		StorageBytes int64 `json:"storage_bytes"`
+		database.UsageSummary
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	summary, err := cfg.db.GetUsageSummary(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		StorageBytes: user.StorageBytes,
+		UsageSummary: summary,
+	})
+}
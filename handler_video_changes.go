@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 500
+)
+
+// videoChange is one entry of the change feed: either the current state of
+// a created or updated video, or a tombstone for a deleted one.
+type videoChange struct {
+	ID        uuid.UUID       `json:"id"`
+	ChangedAt time.Time       `json:"changed_at"`
+	Deleted   bool            `json:"deleted"`
+	DeletedAt *time.Time      `json:"deleted_at,omitempty"`
+	Video     *database.Video `json:"video,omitempty"`
+}
+
+// encodeChangeCursor makes an opaque next_cursor from a feed position.
+func encodeChangeCursor(c database.ChangeCursor) string {
+	return fmt.Sprintf("%d_%s", c.At.Unix(), c.ID)
+}
+
+func decodeChangeCursor(raw string) (database.ChangeCursor, error) {
+	unix, id, ok := strings.Cut(raw, "_")
+	if !ok {
+		return database.ChangeCursor{}, fmt.Errorf("invalid cursor %q", raw)
+	}
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return database.ChangeCursor{}, fmt.Errorf("invalid cursor %q", raw)
+	}
+	videoID, err := uuid.Parse(id)
+	if err != nil {
+		return database.ChangeCursor{}, fmt.Errorf("invalid cursor %q", raw)
+	}
+	return database.ChangeCursor{At: time.Unix(seconds, 0), ID: videoID}, nil
+}
+
+// List the caller's videos created, updated or deleted after ?since=
+// (RFC 3339), oldest change first. Pass next_cursor back as ?cursor= for
+// the next page; once has_more is false the client is up to date.
+func (cfg *apiConfig) handlerVideoChanges(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Changes    []videoChange `json:"changes"`
+		NextCursor string        `json:"next_cursor"`
+		HasMore    bool          `json:"has_more"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	query := r.URL.Query()
+	var after database.ChangeCursor
+	switch {
+	case query.Get("cursor") != "":
+		after, err = decodeChangeCursor(query.Get("cursor"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	case query.Get("since") != "":
+		since, err := time.Parse(time.RFC3339, query.Get("since"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp", err)
+			return
+		}
+		// Stored times have whole-second precision; start from the second
+		// before so changes within since's own second aren't missed
+		after.At = since.Truncate(time.Second).Add(-time.Second)
+	default:
+		respondWithError(w, http.StatusBadRequest, "since or cursor is required", nil)
+		return
+	}
+	limit, _, err := parsePagination(r, defaultChangesLimit, maxChangesLimit)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	videos, tombstones, err := cfg.db.GetVideoChanges(userID, after, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get changes", err)
+		return
+	}
+
+	changes := make([]videoChange, 0, len(videos)+len(tombstones))
+	for _, video := range cfg.prepareVideos(videos) {
+		video := video
+		changes = append(changes, videoChange{ID: video.ID, ChangedAt: video.UpdatedAt, Video: &video})
+	}
+	for _, tombstone := range tombstones {
+		deletedAt := tombstone.DeletedAt
+		changes = append(changes, videoChange{ID: tombstone.VideoID, ChangedAt: deletedAt, Deleted: true, DeletedAt: &deletedAt})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].ChangedAt.Equal(changes[j].ChangedAt) {
+			return changes[i].ChangedAt.Before(changes[j].ChangedAt)
+		}
+		return changes[i].ID.String() < changes[j].ID.String()
+	})
+
+	resp := response{Changes: changes}
+	if len(changes) > limit {
+		resp.Changes = changes[:limit]
+		resp.HasMore = true
+	}
+	next := after
+	if n := len(resp.Changes); n > 0 {
+		next = database.ChangeCursor{At: resp.Changes[n-1].ChangedAt, ID: resp.Changes[n-1].ID}
+	}
+	resp.NextCursor = encodeChangeCursor(next)
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
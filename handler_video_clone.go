@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// Duplicate a video: its file is copied server-side to a new key and a new
+// record is created with the same title, description, metadata, chapters
+// and thumbnail. The processed encode is kept as is, so nothing is
+// transcoded. Previews, DASH packages and chapter tracks aren't copied.
+func (cfg *apiConfig) handlerVideoClone(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	if video.Status != database.VideoStatusReady || video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Only ready videos can be cloned", nil)
+		return
+	}
+	target, srcKey, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in our bucket", nil)
+		return
+	}
+
+	clone, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       video.Title,
+		Description: video.Description,
+		UserID:      userID,
+		Metadata:    video.Metadata,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+	cfg.audit.record(&userID, clone.ID, auditActionCreate)
+	// Until the copy is in place the clone is a draft; don't leave one half made
+	cloned := false
+	defer func() {
+		if cloned {
+			return
+		}
+		if err := cfg.db.DeleteVideo(clone.ID); err != nil {
+			log.Printf("Couldn't remove incomplete clone %s: %v", clone.ID, err)
+		}
+	}()
+
+	key, err := cfg.objectKeyTemplate.build(objectKeyParams{
+		orientation: keyOrientation(srcKey),
+		userID:      userID,
+		videoID:     clone.ID,
+		ext:         path.Ext(srcKey),
+		now:         time.Now(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate random key", err)
+		return
+	}
+	if err := target.copyObject(r.Context(), srcKey, key); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't copy video file", err)
+		return
+	}
+
+	url := target.objectURL(key)
+	err = cfg.db.SetVideoURL(clone.ID, &url, video.SizeBytes)
+	if err == nil {
+		err = cfg.db.SetDuration(clone.ID, video.DurationSeconds)
+	}
+	if err == nil {
+		err = cfg.db.SetChapters(clone.ID, video.Chapters, nil)
+	}
+	if err == nil && video.ThumbnailURL != nil {
+		// Thumbnails are never deleted while a video refers to them, so
+		// the clone can share the original's
+		err = cfg.db.SetThumbnailURL(clone.ID, *video.ThumbnailURL, video.BlurHash, video.ThumbnailSize())
+	}
+	if err == nil && video.AspectRatio != nil {
+		err = cfg.db.SetAspectRatio(clone.ID, *video.AspectRatio)
+	}
+	if err == nil && video.ContentType != nil {
+		err = cfg.db.SetContentType(clone.ID, *video.ContentType)
+	}
+	if info := video.MediaInfo(); err == nil && info != nil {
+		err = cfg.db.SetMediaInfo(clone.ID, *info)
+	}
+	if err == nil {
+		// The copy is byte for byte, so its summary is the same
+		var info json.RawMessage
+		if info, err = cfg.db.GetTechInfo(video.ID); err == nil && info != nil {
+			err = cfg.db.SetTechInfo(clone.ID, info)
+		}
+	}
+	if err == nil && video.DownloadOnly {
+		// The copy kept the original's Content-Disposition
+		err = cfg.db.SetDownloadOnly(clone.ID, true)
+	}
+	if err == nil {
+		err = cfg.db.SetStatus(clone.ID, database.VideoStatusReady)
+	}
+	if err != nil {
+		if delErr := target.deleteObject(r.Context(), key); delErr != nil {
+			log.Printf("Couldn't delete copied object %s: %v", key, delErr)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cloned = true
+
+	if err := cfg.db.AddUserStorageBytes(userID, video.SizeBytes); err != nil {
+		log.Printf("Couldn't update storage usage for user %s: %v", userID, err)
+	} else {
+		cfg.checkQuota(userID)
+	}
+
+	clone, err = cfg.db.GetVideo(clone.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	cfg.respondWithVideo(w, r, http.StatusCreated, cfg.prepareVideo(clone))
+}
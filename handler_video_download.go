@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// apiErrorCoder matches the S3 SDK's API errors without depending on smithy directly.
+type apiErrorCoder interface {
+	ErrorCode() string
+}
+
+// Stream a video's stored file through the server. A Range header is
+// forwarded to S3 and the partial-content headers relayed back, so
+// interrupted downloads can resume from a byte offset.
+func (cfg *apiConfig) handlerVideoDownload(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+	target, key, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in our bucket", nil)
+		return
+	}
+
+	// The slot is held until the copy ends, which is when the client hangs
+	// up or has everything
+	if !cfg.streams.acquire(userID) {
+		respondWithError(w, http.StatusTooManyRequests, "Too many concurrent streams", nil)
+		return
+	}
+	defer cfg.streams.release(userID)
+
+	cfg.recordActivity(userID, videoID, database.ActivityView)
+	proxyObject(w, r, target, key)
+}
+
+// proxyObject streams an S3 object to the client. A Range header is
+// forwarded to S3 and the partial-content headers relayed back.
+func proxyObject(w http.ResponseWriter, r *http.Request, target *storageTarget, key string) {
+	input := &s3.GetObjectInput{
+		Bucket: &target.bucket,
+		Key:    &key,
+	}
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		input.Range = &rangeHeader
+	}
+	out, err := target.client.GetObject(r.Context(), input)
+	if err != nil {
+		var apiErr apiErrorCoder
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			respondWithError(w, http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable", err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't fetch object", err)
+		return
+	}
+	defer out.Body.Close()
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	if out.ContentType != nil {
+		w.Header().Set("Content-Type", *out.ContentType)
+	}
+	if out.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*out.ContentLength, 10))
+	}
+	if out.ContentDisposition != nil {
+		w.Header().Set("Content-Disposition", *out.ContentDisposition)
+	}
+	if out.ETag != nil {
+		w.Header().Set("ETag", *out.ETag)
+	}
+	if out.LastModified != nil {
+		w.Header().Set("Last-Modified", out.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	status := http.StatusOK
+	if out.ContentRange != nil {
+		w.Header().Set("Content-Range", *out.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	if _, err := io.Copy(w, out.Body); err != nil {
+		log.Printf("Proxying %s ended early: %v", key, err)
+	}
+}
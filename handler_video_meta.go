@@ -2,7 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/xaitan80/x-fileserver/internal/auth"
@@ -25,29 +29,39 @@ func (cfg *apiConfig) handlerVideosCreate(w http.ResponseWriter, r *http.Request
 
 	// Parse request body
 	var params struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
+		Title       string          `json:"title"`
+		Description string          `json:"description"`
+		Metadata    json.RawMessage `json:"metadata"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
+	if err := validateMetadata(params.Metadata); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 
 	// Insert new video record
 	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
 		UserID:      userID,
 		Title:       params.Title,
 		Description: params.Description,
+		Metadata:    params.Metadata,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
+	cfg.audit.record(&userID, video.ID, auditActionCreate)
 
-	respondWithJSON(w, http.StatusCreated, video)
+	// Respond with the stored row so the new draft has exactly the shape GET
+	// returns, status included
+	cfg.respondWithVideo(w, r, http.StatusCreated, cfg.prepareVideo(video))
 }
 
-// Get a single video by ID (now returns stored CloudFront URL as-is)
+// Get a single video by ID (now returns stored CloudFront URL as-is). Public
+// videos are open to anyone; others need the owner or a share.
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -58,11 +72,78 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	// Auth is optional for public videos only
+	userID, signedIn := uuid.Nil, false
+	token, err := auth.GetBearerToken(r.Header)
+	if err == nil {
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+		signedIn = err == nil
+	}
+	if !video.Public {
+		if !signedIn {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+		allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+			return
+		}
+		if !allowed {
+			respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+			return
+		}
+	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	// A signed-in caller being handed the playback URL counts as a view
+	if signedIn && video.VideoURL != nil {
+		cfg.recordActivity(userID, videoID, database.ActivityView)
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
+}
+
+// Report whether the stored playback URL for a video can still be used.
+// CloudFront URLs are stored unsigned, so they never expire on their own.
+func (cfg *apiConfig) handlerVideoURLValid(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Valid     bool       `json:"valid"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	valid := false
+	if video.VideoURL != nil {
+		_, _, valid = cfg.locateObject(*video.VideoURL)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Valid: valid,
+	})
 }
 
 // Get all videos for the authenticated user (returns stored CloudFront URLs)
@@ -79,14 +160,165 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Fetch videos for this user
-	videos, err := cfg.db.GetVideos(userID)
+	params := database.ListVideosParams{
+		UserID: userID,
+		Sort:   cfg.defaultVideoSort,
+	}
+
+	// ?include=archived,hidden brings back videos the library normally leaves out
+	if include := r.URL.Query().Get("include"); include != "" {
+		for _, token := range strings.Split(include, ",") {
+			switch strings.TrimSpace(token) {
+			case "archived":
+				params.IncludeArchived = true
+			case "hidden":
+				params.IncludeHidden = true
+			default:
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown include %q; expected archived or hidden", token), nil)
+				return
+			}
+		}
+	}
+	if sort := r.URL.Query().Get("sort"); sort != "" {
+		params.Sort = database.VideoSort(sort)
+		if !database.ValidVideoSort(params.Sort) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown sort %q", sort), nil)
+			return
+		}
+	}
+
+	// Always one page at a time, wrapped with the total and where the next
+	// page starts
+	type page struct {
+		Videos     []database.Video `json:"videos"`
+		TotalCount int              `json:"total_count"`
+		NextOffset *int             `json:"next_offset"`
+	}
+
+	params.Limit, params.Offset, err = parsePagination(r, 25, 100)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	videos, total, err := cfg.db.GetVideosPaged(params)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to get videos", err)
 		return
 	}
+	resp := page{Videos: cfg.prepareVideos(videos), TotalCount: total}
+	if next := params.Offset + len(videos); next < total {
+		resp.NextOffset = &next
+	}
+	cfg.respondWithVideo(w, r, http.StatusOK, resp)
+}
+
+// Update the title, description or library flags of a video the caller owns.
+// Fields left out of the body are unchanged.
+func (cfg *apiConfig) handlerVideoUpdate(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var params struct {
+		Title        *string `json:"title"`
+		Description  *string `json:"description"`
+		Hidden       *bool   `json:"hidden"`
+		Archived     *bool   `json:"archived"`
+		Public       *bool   `json:"public"`
+		DownloadOnly *bool   `json:"download_only"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionEdit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+
+	if params.Title != nil || params.Description != nil {
+		title, description := video.Title, video.Description
+		if params.Title != nil {
+			title = *params.Title
+		}
+		if params.Description != nil {
+			description = *params.Description
+		}
+		if err := cfg.db.SetMetadataFields(videoID, title, description); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	if params.Hidden != nil || params.Archived != nil || params.Public != nil {
+		hidden, archived, public := video.Hidden, video.Archived, video.Public
+		if params.Hidden != nil {
+			hidden = *params.Hidden
+		}
+		if params.Archived != nil {
+			archived = *params.Archived
+		}
+		if params.Public != nil {
+			public = *params.Public
+		}
+		if err := cfg.db.SetFlags(videoID, hidden, archived, public); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	if params.DownloadOnly != nil && *params.DownloadOnly != video.DownloadOnly {
+		// The header lives on the stored object, so change that first
+		if video.VideoURL != nil {
+			if target, key, ok := cfg.locateObject(*video.VideoURL); ok {
+				if err := target.setContentDisposition(r.Context(), key, contentDisposition(*params.DownloadOnly)); err != nil {
+					respondWithError(w, http.StatusInternalServerError, "Couldn't update video file", err)
+					return
+				}
+			}
+		}
+		if err := cfg.db.SetDownloadOnly(videoID, *params.DownloadOnly); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+	}
+	cfg.audit.record(&userID, videoID, auditActionUpdate)
+	cfg.recordActivity(userID, videoID, database.ActivityEdit)
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
 }
 
 // Delete a video by ID
@@ -127,6 +359,12 @@ func (cfg *apiConfig) handlerVideoDelete(w http.ResponseWriter, r *http.Request)
 		respondWithError(w, http.StatusInternalServerError, "Failed to delete video", err)
 		return
 	}
+	cfg.audit.record(&userID, videoID, auditActionDelete)
+	if err := cfg.db.AddUserStorageBytes(userID, -video.SizeBytes); err != nil {
+		log.Printf("Couldn't update storage usage for user %s: %v", userID, err)
+	} else {
+		cfg.checkQuota(userID)
+	}
 
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
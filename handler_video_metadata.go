@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const maxMetadataBytes = 16 << 10 // 16KB
+
+// validateMetadata checks that a client-supplied blob is a JSON object (or
+// null) within the size limit. The contents themselves are never inspected.
+func validateMetadata(raw json.RawMessage) error {
+	if len(raw) > maxMetadataBytes {
+		return fmt.Errorf("metadata must be at most %d bytes", maxMetadataBytes)
+	}
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil
+	}
+	if !json.Valid(trimmed) || trimmed[0] != '{' {
+		return errors.New("metadata must be a JSON object")
+	}
+	return nil
+}
+
+// Get just the metadata blob of a video the caller owns
+func (cfg *apiConfig) handlerVideoMetadataGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+
+	metadata := video.Metadata
+	if metadata == nil {
+		metadata = json.RawMessage("null")
+	}
+	respondWithJSON(w, http.StatusOK, metadata)
+}
+
+// Replace the metadata blob of a video the caller owns. A body of null clears it.
+func (cfg *apiConfig) handlerVideoMetadataUpdate(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMetadataBytes+1))
+	if err != nil {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Metadata is too large", err)
+		return
+	}
+	if err := validateMetadata(body); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionEdit)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+
+	if err := cfg.db.SetMetadata(videoID, bytes.TrimSpace(body)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update metadata", err)
+		return
+	}
+	cfg.audit.record(&userID, videoID, auditActionUpdate)
+	cfg.recordActivity(userID, videoID, database.ActivityEdit)
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideo(video))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// canAccessVideo reports whether userID may use video with the given
+// permission. Owners can do anything; an edit share also allows reading.
+func (cfg *apiConfig) canAccessVideo(video database.Video, userID uuid.UUID, need database.SharePermission) (bool, error) {
+	if video.UserID == userID {
+		return true, nil
+	}
+	// Existing shares grant nothing while sharing is switched off
+	if !cfg.features[featureSharing] {
+		return false, nil
+	}
+	share, err := cfg.db.GetVideoShare(video.ID, userID)
+	if err != nil {
+		return false, err
+	}
+	switch share.Permission {
+	case database.SharePermissionEdit:
+		return true, nil
+	case database.SharePermissionRead:
+		return need == database.SharePermissionRead, nil
+	}
+	return false, nil
+}
+
+// getOwnedVideo authenticates the caller and loads the video in the path,
+// responding with an error and returning false unless the caller owns it.
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request) (database.Video, uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, uuid.Nil, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+		return database.Video{}, uuid.Nil, false
+	}
+	return video, userID, true
+}
+
+// Share a video with another user, identified by email
+func (cfg *apiConfig) handlerVideoShare(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email      string                   `json:"email"`
+		Permission database.SharePermission `json:"permission"`
+	}
+
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if params.Permission == "" {
+		params.Permission = database.SharePermissionRead
+	}
+	if params.Permission != database.SharePermissionRead && params.Permission != database.SharePermissionEdit {
+		respondWithError(w, http.StatusBadRequest, "permission must be \"read\" or \"edit\"", nil)
+		return
+	}
+
+	recipient, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't look up user", err)
+		return
+	}
+	if recipient.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if recipient.ID == userID {
+		respondWithError(w, http.StatusBadRequest, "Can't share a video with yourself", nil)
+		return
+	}
+
+	share, err := cfg.db.ShareVideo(video.ID, recipient.ID, params.Permission)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't share video", err)
+		return
+	}
+	cfg.audit.record(&userID, video.ID, auditActionShare)
+	log.Printf("Video %s shared with user %s (%s)", video.ID, recipient.ID, share.Permission)
+
+	respondWithJSON(w, http.StatusCreated, share)
+}
+
+// List who a video is shared with
+func (cfg *apiConfig) handlerVideoSharesList(w http.ResponseWriter, r *http.Request) {
+	video, _, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	shares, err := cfg.db.GetVideoShares(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get shares", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, shares)
+}
+
+// Revoke a user's access to a video
+func (cfg *apiConfig) handlerVideoUnshare(w http.ResponseWriter, r *http.Request) {
+	video, userID, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	recipientIDString := r.PathValue("userID")
+	recipientID, err := uuid.Parse(recipientIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	revoked, err := cfg.db.RevokeVideoShare(video.ID, recipientID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke share", err)
+		return
+	}
+	if !revoked {
+		respondWithError(w, http.StatusNotFound, "Video isn't shared with that user", nil)
+		return
+	}
+	cfg.audit.record(&userID, video.ID, auditActionUnshare)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// List the videos other users have shared with the caller
+func (cfg *apiConfig) handlerVideosSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videos, err := cfg.db.GetVideosSharedWith(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get shared videos", err)
+		return
+	}
+
+	cfg.respondWithVideo(w, r, http.StatusOK, cfg.prepareVideos(videos))
+}
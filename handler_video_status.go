@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// Poll the processing status of several videos at once with ?ids=a,b,c.
+// Ids that don't exist or aren't the caller's come back with an error.
+func (cfg *apiConfig) handlerVideosStatus(w http.ResponseWriter, r *http.Request) {
+	type progress struct {
+		Stage      string `json:"stage"`
+		BytesDone  int64  `json:"bytes_done"`
+		BytesTotal int64  `json:"bytes_total"`
+	}
+	type result struct {
+		Status   database.VideoStatus `json:"status,omitempty"`
+		Progress *progress            `json:"progress,omitempty"`
+		Error    *string              `json:"error,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	rawIDs := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(rawIDs) == 1 && rawIDs[0] == "" {
+		respondWithError(w, http.StatusBadRequest, "ids is required", nil)
+		return
+	}
+	if len(rawIDs) > maxBatchIDs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d ids per request", maxBatchIDs), nil)
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(rawIDs))
+	for _, raw := range rawIDs {
+		if id, err := uuid.Parse(strings.TrimSpace(raw)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	videos, err := cfg.db.GetVideosByIDs(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	owned := make(map[uuid.UUID]database.Video, len(videos))
+	for _, video := range videos {
+		if video.UserID == userID {
+			owned[video.ID] = video
+		}
+	}
+
+	marker := func(msg string) result { return result{Error: &msg} }
+	results := make(map[string]result, len(rawIDs))
+	for _, raw := range rawIDs {
+		raw = strings.TrimSpace(raw)
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			results[raw] = marker("invalid id")
+			continue
+		}
+		video, ok := owned[id]
+		if !ok {
+			results[raw] = marker("not found")
+			continue
+		}
+
+		res := result{Status: video.Status, Error: video.ProcessingError}
+		// Background jobs such as imports report finer-grained progress
+		if j, ok := cfg.jobs.latestForVideo(id); ok && j.Status == jobStatusRunning {
+			res.Progress = &progress{
+				Stage:      j.Stage,
+				BytesDone:  j.BytesDone,
+				BytesTotal: j.BytesTotal,
+			}
+		}
+		results[raw] = res
+	}
+
+	respondWithJSON(w, http.StatusOK, results)
+}
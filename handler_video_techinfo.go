@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// videoTechInfo is a client-friendly summary of what ffprobe reports about
+// a video file.
+type videoTechInfo struct {
+	Width           int     `json:"width"`
+	Height          int     `json:"height"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	VideoCodec      string  `json:"video_codec"`
+	AudioCodec      *string `json:"audio_codec"`
+	Bitrate         int64   `json:"bitrate"`
+	Framerate       float64 `json:"framerate"`
+	HasAudio        bool    `json:"has_audio"`
+	Aspect          string  `json:"aspect"`
+	SizeBytes       int64   `json:"size_bytes"`
+}
+
+// parseFrameRate reads ffprobe's fractional rates such as "30000/1001".
+func parseFrameRate(raw string) float64 {
+	num, den, found := strings.Cut(raw, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// getTechInfo probes a local file and summarizes it.
+func getTechInfo(ctx context.Context, filePath string) (videoTechInfo, error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return videoTechInfo{}, err
+	}
+	return summarizeProbe(probe)
+}
+
+// summarizeProbe turns ffprobe's output into a videoTechInfo.
+func summarizeProbe(probe ffprobeOutput) (videoTechInfo, error) {
+	var info videoTechInfo
+	foundVideo := false
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if foundVideo {
+				continue
+			}
+			foundVideo = true
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.VideoCodec = stream.CodecName
+			info.Framerate = parseFrameRate(stream.AvgFrameRate)
+		case "audio":
+			if info.HasAudio {
+				continue
+			}
+			info.HasAudio = true
+			codec := stream.CodecName
+			info.AudioCodec = &codec
+		}
+	}
+	if !foundVideo {
+		return videoTechInfo{}, errors.New("no video stream")
+	}
+
+	// ffprobe leaves out format fields it can't work out
+	info.DurationSeconds, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.Bitrate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	info.SizeBytes, _ = strconv.ParseInt(probe.Format.Size, 10, 64)
+	// As getVideoAspectRatio does, going by the first stream
+	info.Aspect = aspectBucket(probe.Streams[0].Width, probe.Streams[0].Height)
+	return info, nil
+}
+
+// storeTechInfo summarizes a processed file and caches it on the video,
+// along with the media info the video record carries.
+func (cfg *apiConfig) storeTechInfo(ctx context.Context, videoID uuid.UUID, filePath string) (videoTechInfo, error) {
+	info, err := getTechInfo(ctx, filePath)
+	if err != nil {
+		return videoTechInfo{}, err
+	}
+	return info, cfg.saveTechInfo(videoID, info)
+}
+
+// saveTechInfo caches a summary on the video.
+func (cfg *apiConfig) saveTechInfo(videoID uuid.UUID, info videoTechInfo) error {
+	dat, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := cfg.db.SetTechInfo(videoID, dat); err != nil {
+		return err
+	}
+	return cfg.db.SetMediaInfo(videoID, database.MediaInfo{
+		Width:      info.Width,
+		Height:     info.Height,
+		VideoCodec: info.VideoCodec,
+		AudioCodec: info.AudioCodec,
+	})
+}
+
+// Return a summary of a video file's technical details: resolution,
+// duration, codecs, bitrate, framerate and so on. It's worked out at upload
+// and cached; videos uploaded before that are probed on first request.
+// Open to the video's owner and to admins.
+func (cfg *apiConfig) handlerVideoTechInfo(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	// Admins use their API key; everyone else must own the video
+	var userID uuid.UUID
+	isAdmin := cfg.authorizeAdmin(r) == nil
+	if !isAdmin {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if !isAdmin && video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+		return
+	}
+
+	cached, err := cfg.db.GetTechInfo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get technical info", err)
+		return
+	}
+	if cached != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(cached)
+		return
+	}
+
+	// Backfill videos processed before the summary was recorded
+	if video.VideoURL == nil || video.Status != database.VideoStatusReady {
+		respondWithError(w, http.StatusConflict, "Video has no processed file yet", nil)
+		return
+	}
+	target, key, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in our bucket", nil)
+		return
+	}
+	// Probe the object in place rather than downloading all of it: ffprobe
+	// only reads the headers, over range requests, and the URL only needs
+	// to outlast the probe
+	objectURL, err := target.presignGet(r.Context(), key, ffprobeTimeout+time.Minute)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URL", err)
+		return
+	}
+	probe, err := runProbe(r.Context(), objectURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	info, err := summarizeProbe(probe)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read video file", err)
+		return
+	}
+	if err := cfg.saveTechInfo(videoID, info); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save technical info", err)
+		return
+	}
+	log.Printf("Recorded technical info for video %s on first request", videoID)
+	respondWithJSON(w, http.StatusOK, info)
+}
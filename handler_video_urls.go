@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// maxBatchIDs caps how many videos one batch request can ask about
+const maxBatchIDs = 100
+
+// Look up the playback URLs of many videos at once. Each id gets either a
+// URL or an error, so one bad id doesn't fail the whole batch.
+func (cfg *apiConfig) handlerVideoURLsBatch(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IDs []string `json:"ids"`
+	}
+	type result struct {
+		URL       *string    `json:"url,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		Error     string     `json:"error,omitempty"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if len(params.IDs) == 0 {
+		respondWithError(w, http.StatusBadRequest, "ids is required", nil)
+		return
+	}
+	if len(params.IDs) > maxBatchIDs {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("At most %d ids per request", maxBatchIDs), nil)
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(params.IDs))
+	for _, raw := range params.IDs {
+		if id, err := uuid.Parse(raw); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	videos, err := cfg.db.GetVideosByIDs(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	owned := make(map[uuid.UUID]database.Video, len(videos))
+	for _, video := range cfg.prepareVideos(videos) {
+		// Someone else's video is reported exactly like a missing one
+		if video.UserID == userID {
+			owned[video.ID] = video
+		}
+	}
+
+	results := make(map[string]result, len(params.IDs))
+	for _, raw := range params.IDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			results[raw] = result{Error: "invalid id"}
+			continue
+		}
+		video, ok := owned[id]
+		switch {
+		case !ok:
+			results[raw] = result{Error: "not found"}
+		case video.VideoURL == nil:
+			results[raw] = result{Error: "no uploaded file"}
+		default:
+			// CloudFront URLs are unsigned, so they never expire
+			results[raw] = result{URL: video.VideoURL}
+			cfg.recordActivity(userID, id, database.ActivityView)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+// List the caller's videos grouped by aspect ratio ("16:9", "9:16", "other",
+// and "unknown" for videos without a processed file). limit and offset page
+// through each group independently, so a gallery section can load more of
+// one ratio without re-fetching the others.
+func (cfg *apiConfig) handlerVideosGroupedByAspect(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	limit, offset, err := parsePagination(r, 50, 500)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	groups, err := cfg.db.GetVideosByAspect(userID, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to get videos", err)
+		return
+	}
+	for aspect, group := range groups {
+		group.Videos = cfg.prepareVideos(group.Videos)
+		groups[aspect] = group
+	}
+
+	respondWithJSON(w, http.StatusOK, groups)
+}
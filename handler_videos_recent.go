@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// recordActivity notes a view or edit for the user's recent videos. It's
+// bookkeeping, so failing to record one doesn't fail the request.
+func (cfg *apiConfig) recordActivity(userID, videoID uuid.UUID, event database.ActivityEvent) {
+	if err := cfg.db.RecordActivity(userID, videoID, event); err != nil {
+		log.Printf("Couldn't record %s of video %s by user %s: %v", event, videoID, userID, err)
+	}
+}
+
+// List the videos the caller most recently watched or changed, newest
+// first, for a "continue watching" row. A view is recorded whenever the
+// caller is handed a video's playback URL and an edit whenever they change
+// its details. ?event=view or ?event=edit counts only that kind; by default
+// each video appears once, at its latest event of either kind.
+func (cfg *apiConfig) handlerVideosRecent(w http.ResponseWriter, r *http.Request) {
+	type recentVideo struct {
+		Video   database.Video         `json:"video"`
+		Event   database.ActivityEvent `json:"event"`
+		EventAt time.Time              `json:"event_at"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	event := database.ActivityEvent(r.URL.Query().Get("event"))
+	if event != "" && !database.ValidActivityEvent(event) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event %q; expected view or edit", event), nil)
+		return
+	}
+	limit, offset, err := parsePagination(r, 20, 100)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	recent, err := cfg.db.ListRecentVideos(userID, event, limit, offset)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get recent videos", err)
+		return
+	}
+
+	response := make([]recentVideo, 0, len(recent))
+	for _, rv := range recent {
+		response = append(response, recentVideo{
+			Video:   cfg.prepareVideo(rv.Video),
+			Event:   rv.Event,
+			EventAt: rv.EventAt,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// Report whether the server can take traffic. Maintenance mode doesn't make
+// it unready, since reads keep working, but it is reported.
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Status      string `json:"status"`
+		Maintenance bool   `json:"maintenance"`
+		Error       string `json:"error,omitempty"`
+	}
+
+	if err := cfg.db.Ping(); err != nil {
+		respondWithJSON(w, http.StatusServiceUnavailable, response{
+			Status:      "unavailable",
+			Maintenance: cfg.maintenance.Load(),
+			Error:       "database unreachable",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Status:      "ready",
+		Maintenance: cfg.maintenance.Load(),
+	})
+}
+
+// Describe what this deployment supports so clients can adapt their UI
+func (cfg *apiConfig) handlerCapabilities(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Maintenance       bool     `json:"maintenance"`
+		UploadTypes       []string `json:"upload_types"`
+		AsyncUploads      bool     `json:"async_uploads"`
+		TranscodeProfiles []string `json:"transcode_profiles"`
+		DefaultProfile    string   `json:"default_profile"`
+		PreviewMode       string   `json:"preview_mode"`
+		DASH              bool     `json:"dash"`
+		Regions           []string `json:"regions"`
+		Features          []string `json:"features"`
+	}
+
+	profiles := make([]string, 0, len(cfg.transcodeProfiles))
+	for name := range cfg.transcodeProfiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+
+	regions := make([]string, 0, len(cfg.storageTargets))
+	for _, target := range cfg.storageTargets {
+		regions = append(regions, target.region)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Maintenance:       cfg.maintenance.Load(),
+		UploadTypes:       cfg.videoTypes(),
+		AsyncUploads:      cfg.uploadAsync,
+		TranscodeProfiles: profiles,
+		DefaultProfile:    cfg.defaultTranscodeProfile,
+		PreviewMode:       cfg.preview.mode,
+		DASH:              cfg.dashEnabled,
+		Regions:           regions,
+		Features:          cfg.features.enabled(),
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecorder passes a response through while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes a handler safe to retry: a request carrying an
+// Idempotency-Key header that the same user has already used gets the
+// original response replayed instead of running the handler again.
+// Requests without the header, or that fail auth, go straight through.
+func (cfg *apiConfig) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key is too long", nil)
+			return
+		}
+
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		if err := cfg.db.DeleteExpiredIdempotencyKeys(time.Now().UTC().Add(-cfg.idempotencyKeyTTL)); err != nil {
+			log.Printf("Couldn't expire idempotency keys: %v", err)
+		}
+
+		requestPath := r.Method + " " + r.URL.Path
+		claimed, err := cfg.db.ClaimIdempotencyKey(userID, key, requestPath)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store idempotency key", err)
+			return
+		}
+		if !claimed {
+			record, err := cfg.db.GetIdempotencyKey(userID, key)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't look up idempotency key", err)
+				return
+			}
+			switch {
+			case record.RequestPath == "":
+				// The key expired or was released since the claim failed
+				respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key just finished; retry it", nil)
+			case record.RequestPath != requestPath:
+				respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", nil)
+			case record.StatusCode == 0:
+				respondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress", nil)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.StatusCode)
+				w.Write(record.ResponseBody)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		// Server errors and abandoned requests may succeed on retry, so
+		// forget the key rather than replaying the failure
+		if rec.status == 0 || rec.status >= 500 {
+			if err := cfg.db.DeleteIdempotencyKey(userID, key); err != nil {
+				log.Printf("Couldn't release idempotency key: %v", err)
+			}
+			return
+		}
+		if err := cfg.db.CompleteIdempotencyKey(userID, key, rec.status, rec.body.Bytes()); err != nil {
+			log.Printf("Couldn't save idempotent response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	importDownloadTimeout = time.Hour
+	importMaxRedirects    = 5
+)
+
+// importConfig limits where and how much the server will fetch on a user's behalf.
+type importConfig struct {
+	maxBytes int64
+	// allowedHosts, when non-empty, is the only hosts imports may use
+	allowedHosts []string
+	deniedHosts  []string
+}
+
+// parseHostList reads a comma-separated list of host names.
+func parseHostList(spec string) []string {
+	var hosts []string
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// hostMatches reports whether host is one of hosts or a subdomain of one.
+func hostMatches(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImportURL rejects URLs the server shouldn't fetch. Addresses are
+// checked again when connecting, since a name can resolve anywhere.
+func (c importConfig) checkImportURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https URLs can be imported")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("URL has no host")
+	}
+	if hostMatches(host, c.deniedHosts) {
+		return fmt.Errorf("imports from %s aren't allowed", host)
+	}
+	if len(c.allowedHosts) > 0 && !hostMatches(host, c.allowedHosts) {
+		return fmt.Errorf("imports from %s aren't allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("imports from %s aren't allowed", host)
+	}
+	return nil
+}
+
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is a globally routable unicast address.
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() &&
+		!ip.IsPrivate() &&
+		!ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!carrierGradeNAT.Contains(ip)
+}
+
+// publicDialer only connects to public addresses. The check runs on the
+// dialled address, so DNS rebinding to internal hosts is refused too.
+func publicDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("refusing to connect to non-public address %s", host)
+			}
+			return nil
+		},
+	}
+}
+
+// client returns an HTTP client that only connects to public addresses,
+// following redirects only to URLs imports may use.
+func (c importConfig) client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			// No proxy: the dial check must see the real destination
+			Proxy:                 nil,
+			DialContext:           publicDialer().DialContext,
+			TLSHandshakeTimeout:   30 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= importMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return c.checkImportURL(req.URL)
+		},
+	}
+}
+
+// countingWriter reports how many bytes have passed through it.
+type countingWriter struct {
+	w     io.Writer
+	added func(n int64)
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.added(int64(n))
+	return n, err
+}
+
+// Import a video hosted elsewhere: the server downloads it and runs it
+// through the normal processing pipeline in the background, returning a
+// job whose progress can be polled at /api/jobs/{jobID}
+func (cfg *apiConfig) handlerVideoImport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL     string `json:"url"`
+		Profile string `json:"profile"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	sourceURL, err := url.Parse(params.URL)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid url", err)
+		return
+	}
+	if err := cfg.imports.checkImportURL(sourceURL); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if params.Profile == "" {
+		params.Profile = cfg.transcodeProfileFor(userID)
+	}
+	profile, ok := cfg.transcodeProfiles[params.Profile]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown transcode profile %q", params.Profile), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this video", nil)
+		return
+	}
+	if video.Status.Busy() {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+
+	// Of concurrent requests for the same video, only one gets to start
+	claimed, err := cfg.db.SwapStatus(videoID, video.Status, database.VideoStatusProcessing)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update video status", err)
+		return
+	}
+	if !claimed {
+		respondWithError(w, http.StatusConflict, "Video is already being uploaded or processed", nil)
+		return
+	}
+
+	j := cfg.jobs.start("import", userID, videoID)
+	go cfg.runImport(j, video, sourceURL, profile, nil, cfg.uploadTarget(r), nil)
+
+	respondWithJSON(w, http.StatusAccepted, j.snapshot())
+}
+
+// runImport downloads and ingests a remote video, recording the outcome on
+// both the job and the video, and reporting it to callbackURL if there is one.
+func (cfg *apiConfig) runImport(j *job, video database.Video, sourceURL *url.URL, profile transcodeProfile, trim *trimRange, target *storageTarget, callbackURL *url.URL) {
+	defer cfg.recoverJob(j, video.ID)
+	ctx, untrack := cfg.jobs.trackUpload(context.Background(), video.ID)
+	defer untrack()
+
+	failReason := "import failed"
+	err := func() error {
+		j.setStage("downloading")
+		srcPath, err := cfg.downloadImport(ctx, j, sourceURL)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(srcPath)
+
+		// The sender's Content-Type is only a hint, so the type is left for
+		// ingest to detect from the file
+		err = cfg.ingestVideo(ctx, ingestRequest{
+			video:   video,
+			userID:  video.UserID,
+			srcPath: srcPath,
+			profile: profile,
+			trim:    trim,
+			target:  target,
+			stage:   j.setStage,
+			queuePosition: func(position int) {
+				j.update(func(s *jobState) { s.QueuePosition = position })
+			},
+		})
+		var ingestErr *ingestError
+		if errors.As(err, &ingestErr) {
+			failReason = ingestErr.reason
+			return errors.New(ingestErr.message)
+		}
+		return err
+	}()
+	if err != nil && context.Cause(ctx) == errUploadCancelled {
+		failReason = "import cancelled"
+		err = errUploadCancelled
+	}
+
+	j.finish(err)
+	if err != nil {
+		log.Printf("Import of video %s from %s failed: %v", video.ID, sourceURL.Host, err)
+		if err := cfg.db.FailVideo(video.ID, failReason); err != nil {
+			log.Printf("Couldn't mark video %s as failed: %v", video.ID, err)
+		}
+	}
+	if callbackURL != nil {
+		cfg.sendUploadCallback(callbackURL, video.ID)
+	}
+}
+
+// downloadImport fetches the source into a temp file, enforcing the size
+// cap and content type, and returns the file's path. Only the fetch is held
+// to importDownloadTimeout; queueing and transcoding have their own limits.
+func (cfg *apiConfig) downloadImport(ctx context.Context, j *job, sourceURL *url.URL) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, importDownloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := cfg.imports.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("couldn't fetch source: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("source returned %s", resp.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !(cfg.allowedVideoTypes[mediaType] || mediaType == "application/octet-stream") {
+		return "", fmt.Errorf("source isn't a video (got %q)", resp.Header.Get("Content-Type"))
+	}
+	if resp.ContentLength > cfg.imports.maxBytes {
+		return "", fmt.Errorf("source is larger than %d bytes", cfg.imports.maxBytes)
+	}
+	if resp.ContentLength > 0 {
+		j.update(func(s *jobState) { s.BytesTotal = resp.ContentLength })
+	}
+
+	tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	out := countingWriter{w: tempFile, added: func(n int64) {
+		j.update(func(s *jobState) { s.BytesDone += n })
+	}}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, cfg.imports.maxBytes+1))
+	if err == nil && n > cfg.imports.maxBytes {
+		err = fmt.Errorf("source is larger than %d bytes", cfg.imports.maxBytes)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
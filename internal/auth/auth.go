@@ -20,6 +20,61 @@ const (
 	TokenTypeAccess TokenType = "tubely-access"
 )
 
+// signingMethod is the only algorithm access tokens are signed with, and so
+// the only one ValidateJWT accepts. Trusting the token header's alg would
+// allow "none" or algorithm-confusion forgeries.
+var signingMethod = jwt.SigningMethodHS256
+
+// clockSkewLeeway is how far past exp, or before nbf/iat, a token is still
+// accepted, so small clock differences between hosts don't cause 401s.
+var clockSkewLeeway = 30 * time.Second
+
+// SetClockSkewLeeway changes the leeway ValidateJWT allows. Call it during
+// startup, before any tokens are validated.
+func SetClockSkewLeeway(leeway time.Duration) {
+	clockSkewLeeway = leeway
+}
+
+// signingKeyID is put in the kid header of every token signed with the
+// current secret. previousSecrets are secrets retired by rotation, by key
+// ID, still accepted for verification until the tokens they signed expire.
+var (
+	signingKeyID    string
+	previousSecrets map[string]string
+)
+
+// SetSigningKeys names the current secret's key ID and the retired secrets
+// still accepted. Call it during startup, before any tokens are made or
+// validated.
+func SetSigningKeys(currentKeyID string, previous map[string]string) {
+	signingKeyID = currentKeyID
+	previousSecrets = previous
+}
+
+// signToken signs a token with the current secret, labelled with its key ID.
+func signToken(token *jwt.Token, tokenSecret string) (string, error) {
+	if signingKeyID != "" {
+		token.Header["kid"] = signingKeyID
+	}
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// verificationKey picks the secret a token was signed with by its kid
+// header. Tokens without one predate key IDs and are tried with the current
+// secret here; parseJWT falls back to the previous ones for them.
+func verificationKey(tokenSecret string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" || kid == signingKeyID {
+			return []byte(tokenSecret), nil
+		}
+		if secret, ok := previousSecrets[kid]; ok {
+			return []byte(secret), nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+}
+
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
 func HashPassword(password string) (string, error) {
@@ -39,23 +94,46 @@ func MakeJWT(
 	tokenSecret string,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+	token := jwt.NewWithClaims(signingMethod, jwt.RegisteredClaims{
 		Issuer:    string(TokenTypeAccess),
 		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
 		Subject:   userID.String(),
 	})
-	return token.SignedString(signingKey)
+	return signToken(token, tokenSecret)
+}
+
+// parseJWT parses and verifies a token signed with the current secret or a
+// previous one, enforcing the signing algorithm and expiry.
+func parseJWT(tokenString, tokenSecret string) (*jwt.Token, error) {
+	parse := func(keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+		return jwt.ParseWithClaims(
+			tokenString,
+			&jwt.RegisteredClaims{},
+			keyFunc,
+			jwt.WithValidMethods([]string{signingMethod.Alg()}),
+			jwt.WithLeeway(clockSkewLeeway),
+		)
+	}
+	token, err := parse(verificationKey(tokenSecret))
+	// A token without a kid was signed before key IDs were configured, with
+	// whatever secret was current then, which may since have been retired.
+	// Trying the previous secrets keeps the first rotation seamless.
+	if errors.Is(err, jwt.ErrTokenSignatureInvalid) && token != nil && token.Header["kid"] == nil {
+		for _, secret := range previousSecrets {
+			token, err = parse(func(*jwt.Token) (interface{}, error) {
+				return []byte(secret), nil
+			})
+			if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+				break
+			}
+		}
+	}
+	return token, err
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
-	)
+	token, err := parseJWT(tokenString, tokenSecret)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// forgeToken builds a token by hand with whatever header the caller likes,
+// signed with secret under signWith (unsigned when signWith is nil).
+func forgeToken(t *testing.T, header map[string]any, claims jwt.RegisteredClaims, signWith jwt.SigningMethod, secret string) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingString := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	if signWith == nil {
+		return signingString + "."
+	}
+	sig, err := signWith.Sign(signingString, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims(userID uuid.UUID) jwt.RegisteredClaims {
+	now := time.Now().UTC()
+	return jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeAccess),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		Subject:   userID.String(),
+	}
+}
+
+func TestValidateJWTRoundTrip(t *testing.T) {
+	userID := uuid.New()
+	token, err := MakeJWT(userID, "secret", time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	got, err := ValidateJWT(token, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	if got != userID {
+		t.Errorf("got user %s, want %s", got, userID)
+	}
+	if _, err := ValidateJWT(token, "other secret"); err == nil {
+		t.Error("token validated with the wrong secret")
+	}
+}
+
+func TestValidateJWTRejectsForgedAlgorithms(t *testing.T) {
+	const secret = "secret"
+	claims := validClaims(uuid.New())
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "alg none, unsigned",
+			token: forgeToken(t, map[string]any{"alg": "none", "typ": "JWT"}, claims, nil, secret),
+		},
+		{
+			name:  "alg None, unsigned",
+			token: forgeToken(t, map[string]any{"alg": "None", "typ": "JWT"}, claims, nil, secret),
+		},
+		{
+			name:  "alg missing, HS256 signature",
+			token: forgeToken(t, map[string]any{"typ": "JWT"}, claims, jwt.SigningMethodHS256, secret),
+		},
+		{
+			// An HS256 signature relabelled as another algorithm must not be
+			// verified under whatever the header claims
+			name:  "alg HS512 header, HS256 signature",
+			token: forgeToken(t, map[string]any{"alg": "HS512", "typ": "JWT"}, claims, jwt.SigningMethodHS256, secret),
+		},
+		{
+			name:  "alg RS256 header, HS256 signature",
+			token: forgeToken(t, map[string]any{"alg": "RS256", "typ": "JWT"}, claims, jwt.SigningMethodHS256, secret),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, err := ValidateJWT(tt.token, secret); err == nil {
+				t.Errorf("forged token accepted for user %s", id)
+			}
+		})
+	}
+
+	// The same claims signed properly do validate, so the rejections above
+	// are down to the algorithm alone
+	genuine := forgeToken(t, map[string]any{"alg": "HS256", "typ": "JWT"}, claims, jwt.SigningMethodHS256, secret)
+	if _, err := ValidateJWT(genuine, secret); err != nil {
+		t.Errorf("genuine HS256 token rejected: %v", err)
+	}
+}
+
+func TestValidateJWTDuringKeyRotation(t *testing.T) {
+	t.Cleanup(func() { SetSigningKeys("", nil) })
+	userID := uuid.New()
+
+	// Before any rotation: one secret and, on older deployments, no key ID
+	SetSigningKeys("", nil)
+	legacy, err := MakeJWT(userID, "old secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigningKeys("k1", nil)
+	labelled, err := MakeJWT(userID, "old secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: new tokens use k2, k1's secret is kept for verification
+	SetSigningKeys("k2", map[string]string{"k1": "old secret"})
+	current, err := MakeJWT(userID, "new secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{
+		"token without kid signed with the old secret": legacy,
+		"token with old kid":                           labelled,
+		"token with current kid":                       current,
+	} {
+		if got, err := ValidateJWT(token, "new secret"); err != nil || got != userID {
+			t.Errorf("%s: got %s, %v; want %s", name, got, err, userID)
+		}
+	}
+
+	// A kid-less token is only accepted if some known secret signed it
+	SetSigningKeys("", nil)
+	stranger, err := MakeJWT(userID, "unknown secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigningKeys("k2", map[string]string{"k1": "old secret"})
+	if _, err := ValidateJWT(stranger, "new secret"); err == nil {
+		t.Error("token signed with an unknown secret accepted")
+	}
+
+	// Once the overlap ends the old secret stops working
+	SetSigningKeys("k2", nil)
+	for name, token := range map[string]string{
+		"token without kid signed with the old secret": legacy,
+		"token with old kid":                           labelled,
+	} {
+		if _, err := ValidateJWT(token, "new secret"); err == nil {
+			t.Errorf("%s: accepted after its secret was retired", name)
+		}
+	}
+}
+
+// TestValidateUploadTicketDuringKeyRotation checks tickets get the same
+// fallback to previous secrets as access tokens.
+func TestValidateUploadTicketDuringKeyRotation(t *testing.T) {
+	t.Cleanup(func() { SetSigningKeys("", nil) })
+	userID, videoID := uuid.New(), uuid.New()
+
+	SetSigningKeys("", nil)
+	legacy, err := MakeUploadTicket(userID, videoID, "old secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSigningKeys("k2", map[string]string{"k1": "old secret"})
+	gotUser, gotVideo, err := ValidateUploadTicket(legacy, "new secret")
+	if err != nil || gotUser != userID || gotVideo != videoID {
+		t.Errorf("ticket without kid: got %s, %s, %v; want %s, %s", gotUser, gotVideo, err, userID, videoID)
+	}
+
+	// An access token signed with the same secret is still no ticket
+	access, err := MakeJWT(userID, "new secret", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ValidateUploadTicket(access, "new secret"); err == nil {
+		t.Error("access token accepted as an upload ticket")
+	}
+}
+
+func TestValidateJWTClockSkewLeeway(t *testing.T) {
+	t.Cleanup(func() { SetClockSkewLeeway(30 * time.Second) })
+	SetClockSkewLeeway(30 * time.Second)
+	const secret = "secret"
+	userID := uuid.New()
+	header := map[string]any{"alg": "HS256", "typ": "JWT"}
+	now := time.Now().UTC()
+
+	expiredBy := func(d time.Duration) jwt.RegisteredClaims {
+		claims := validClaims(userID)
+		claims.IssuedAt = jwt.NewNumericDate(now.Add(-time.Hour))
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(-d))
+		return claims
+	}
+	notValidFor := func(d time.Duration) jwt.RegisteredClaims {
+		claims := validClaims(userID)
+		claims.NotBefore = jwt.NewNumericDate(now.Add(d))
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.RegisteredClaims
+		ok     bool
+	}{
+		{"expired 5s ago", expiredBy(5 * time.Second), true},
+		{"expired 20s ago", expiredBy(20 * time.Second), true},
+		{"expired 2m ago", expiredBy(2 * time.Minute), false},
+		{"valid in 5s", notValidFor(5 * time.Second), true},
+		{"valid in 20s", notValidFor(20 * time.Second), true},
+		{"valid in 2m", notValidFor(2 * time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := forgeToken(t, header, tt.claims, jwt.SigningMethodHS256, secret)
+			got, err := ValidateJWT(token, secret)
+			switch {
+			case tt.ok && err != nil:
+				t.Errorf("rejected within the leeway: %v", err)
+			case tt.ok && got != userID:
+				t.Errorf("got user %s, want %s", got, userID)
+			case !tt.ok && err == nil:
+				t.Error("accepted beyond the leeway")
+			}
+		})
+	}
+
+	// Without leeway a token that has just expired is rejected
+	SetClockSkewLeeway(0)
+	token := forgeToken(t, header, expiredBy(5*time.Second), jwt.SigningMethodHS256, secret)
+	if _, err := ValidateJWT(token, secret); err == nil {
+		t.Error("expired token accepted with no leeway")
+	}
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const TokenTypeUploadTicket TokenType = "tubely-upload"
+
+// UploadTicketHeader carries an upload ticket on the upload request.
+const UploadTicketHeader = "X-Upload-Ticket"
+
+// MakeUploadTicket issues a short-lived token allowing userID to upload the
+// file for videoID. Tickets have their own issuer, so they can't be used as
+// access tokens.
+func MakeUploadTicket(
+	userID uuid.UUID,
+	videoID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	token := jwt.NewWithClaims(signingMethod, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeUploadTicket),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   userID.String(),
+		ID:        videoID.String(),
+	})
+	return signToken(token, tokenSecret)
+}
+
+// ValidateUploadTicket checks a ticket's signature and expiry and returns
+// the user and video it was issued for.
+func ValidateUploadTicket(ticket, tokenSecret string) (userID, videoID uuid.UUID, err error) {
+	token, err := parseJWT(ticket, tokenSecret)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	if !ok || claims.Issuer != string(TokenTypeUploadTicket) {
+		return uuid.Nil, uuid.Nil, errors.New("invalid issuer")
+	}
+
+	userID, err = uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
+	}
+	videoID, err = uuid.Parse(claims.ID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("invalid video ID")
+	}
+	return userID, videoID, nil
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ActivityEvent is something a user did with a video that counts towards
+// their recent videos.
+type ActivityEvent string
+
+const (
+	// ActivityView is recorded when a user is handed a video's playback URL
+	ActivityView ActivityEvent = "view"
+	// ActivityEdit is recorded when a user changes a video's details
+	ActivityEdit ActivityEvent = "edit"
+)
+
+// ValidActivityEvent reports whether event is one ListRecentVideos filters by.
+func ValidActivityEvent(event ActivityEvent) bool {
+	return event == ActivityView || event == ActivityEdit
+}
+
+// RecentVideo is a video along with the user's latest activity on it.
+type RecentVideo struct {
+	Video   Video
+	Event   ActivityEvent
+	EventAt time.Time
+}
+
+// RecordActivity notes that the user just viewed or edited the video. Only
+// the latest time of each event is kept.
+func (c Client) RecordActivity(userID, videoID uuid.UUID, event ActivityEvent) error {
+	query := `
+		INSERT INTO video_activity (user_id, video_id, event, at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, video_id, event) DO UPDATE SET
+			at = excluded.at
+	`
+	// Stored with sub-second precision so quick successive views still order
+	_, err := c.db.Exec(query, userID.String(), videoID.String(), event, time.Now().UTC())
+	return err
+}
+
+// ListRecentVideos returns the videos a user has most recently viewed or
+// edited, newest first, each with its latest event. An empty event counts
+// both. Videos the user can no longer see, because they're neither theirs,
+// shared with them nor public, are left out.
+func (c Client) ListRecentVideos(userID uuid.UUID, event ActivityEvent, limit, offset int) ([]RecentVideo, error) {
+	query := `
+	SELECT` + videoColumns + `,
+		activity_event,
+		activity_at
+	FROM (
+		SELECT videos.*,
+			a.event AS activity_event,
+			a.at AS activity_at,
+			ROW_NUMBER() OVER (
+				PARTITION BY a.video_id
+				ORDER BY a.at DESC
+			) AS position
+		FROM video_activity a
+		JOIN videos ON videos.id = a.video_id
+		WHERE a.user_id = ?
+			AND (? = '' OR a.event = ?)
+			AND (
+				videos.user_id = a.user_id
+				OR videos.public = 1
+				OR EXISTS (
+					SELECT 1 FROM video_shares s
+					WHERE s.video_id = videos.id AND s.user_id = a.user_id
+				)
+			)
+	)
+	WHERE position = 1
+	ORDER BY activity_at DESC, id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := c.db.Query(query, userID.String(), event, event, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recent := []RecentVideo{}
+	for rows.Next() {
+		var r RecentVideo
+		video, err := scanVideo(withExtraColumns(rows, &r.Event, &r.EventAt))
+		if err != nil {
+			return nil, err
+		}
+		r.Video = video
+		recent = append(recent, r)
+	}
+	return recent, rows.Err()
+}
+
+// extraColumnScanner scans a row holding a video's columns followed by
+// others, so scanVideo can be reused for it.
+type extraColumnScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func withExtraColumns(row rowScanner, extra ...interface{}) rowScanner {
+	return extraColumnScanner{row: row, extra: extra}
+}
+
+func (s extraColumnScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AudioTrack is an extra language track for a video, stored next to its
+// file. A video has at most one track per language.
+type AudioTrack struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Language  string    `json:"language"`
+	Label     string    `json:"label"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateAudioTrackParams struct {
+	VideoID  uuid.UUID
+	Language string
+	Label    string
+	URL      string
+}
+
+// ErrAudioTrackExists is returned when a video already has a track in the
+// language being added.
+var ErrAudioTrackExists = errors.New("video already has an audio track in this language")
+
+func (c Client) CreateAudioTrack(params CreateAudioTrackParams) (AudioTrack, error) {
+	query := `
+		INSERT INTO video_audio_tracks (video_id, language, label, url, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (video_id, language) DO NOTHING
+	`
+	res, err := c.db.Exec(query, params.VideoID, params.Language, params.Label, params.URL)
+	if err != nil {
+		return AudioTrack{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return AudioTrack{}, err
+	}
+	if n == 0 {
+		return AudioTrack{}, ErrAudioTrackExists
+	}
+	return c.GetAudioTrack(params.VideoID, params.Language)
+}
+
+// GetAudioTrack returns a video's track in a language, or a zero track if
+// there isn't one.
+func (c Client) GetAudioTrack(videoID uuid.UUID, language string) (AudioTrack, error) {
+	query := `
+		SELECT video_id, language, label, url, created_at
+		FROM video_audio_tracks
+		WHERE video_id = ? AND language = ?
+	`
+	track, err := scanAudioTrack(c.db.QueryRow(query, videoID, language))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return AudioTrack{}, nil
+		}
+		return AudioTrack{}, err
+	}
+	return track, nil
+}
+
+// GetAudioTracks lists a video's audio tracks by language.
+func (c Client) GetAudioTracks(videoID uuid.UUID) ([]AudioTrack, error) {
+	query := `
+		SELECT video_id, language, label, url, created_at
+		FROM video_audio_tracks
+		WHERE video_id = ?
+		ORDER BY language ASC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks := []AudioTrack{}
+	for rows.Next() {
+		track, err := scanAudioTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, rows.Err()
+}
+
+// DeleteAudioTrack removes a video's track in a language, reporting whether
+// it existed.
+func (c Client) DeleteAudioTrack(videoID uuid.UUID, language string) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM video_audio_tracks WHERE video_id = ? AND language = ?", videoID, language)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func scanAudioTrack(row rowScanner) (AudioTrack, error) {
+	var track AudioTrack
+	err := row.Scan(&track.VideoID, &track.Language, &track.Label, &track.URL, &track.CreatedAt)
+	if err != nil {
+		return AudioTrack{}, err
+	}
+	return track, nil
+}
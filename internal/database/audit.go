@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditEvent struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ActorID   *uuid.UUID `json:"actor_id"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	Action    string     `json:"action"`
+}
+
+type CreateAuditEventParams struct {
+	ActorID *uuid.UUID
+	VideoID uuid.UUID
+	Action  string
+}
+
+// AuditFilter narrows an audit query; zero values match everything.
+type AuditFilter struct {
+	ActorID *uuid.UUID
+	VideoID *uuid.UUID
+	Action  string
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Offset  int
+}
+
+func (c Client) CreateAuditEvent(params CreateAuditEventParams) error {
+	query := `
+	INSERT INTO audit_log (
+		created_at,
+		actor_id,
+		video_id,
+		action
+	) VALUES (CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	var actorID interface{}
+	if params.ActorID != nil {
+		actorID = params.ActorID.String()
+	}
+	_, err := c.db.Exec(query, actorID, params.VideoID.String(), params.Action)
+	return err
+}
+
+// GetAuditEvents returns matching events, newest first.
+func (c Client) GetAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if filter.ActorID != nil {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, filter.ActorID.String())
+	}
+	if filter.VideoID != nil {
+		conditions = append(conditions, "video_id = ?")
+		args = append(args, filter.VideoID.String())
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC().Format(timestampLayout))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until.UTC().Format(timestampLayout))
+	}
+
+	query := `
+	SELECT id, created_at, actor_id, video_id, action
+	FROM audit_log
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+	query += "ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var actorID sql.NullString
+		var videoID string
+		if err := rows.Scan(&event.ID, &event.CreatedAt, &actorID, &videoID, &event.Action); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			id, err := uuid.Parse(actorID.String)
+			if err != nil {
+				return nil, err
+			}
+			event.ActorID = &id
+		}
+		event.VideoID, err = uuid.Parse(videoID)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ChangeCursor is a position in a user's change feed: changes are ordered
+// by time, then by video ID.
+type ChangeCursor struct {
+	At time.Time
+	ID uuid.UUID
+}
+
+// VideoTombstone records that a video was deleted.
+type VideoTombstone struct {
+	VideoID   uuid.UUID `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// GetVideoChanges returns up to limit of a user's videos updated after the
+// cursor, and up to limit of their videos deleted after it, each ordered by
+// time then ID. Callers merge the two and keep the first limit.
+func (c Client) GetVideoChanges(userID uuid.UUID, after ChangeCursor, limit int) ([]Video, []VideoTombstone, error) {
+	at := after.At.UTC().Format(timestampLayout)
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+		AND (updated_at > ? OR (updated_at = ? AND id > ?))
+	ORDER BY updated_at ASC, id ASC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, at, at, after.ID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	videos, err := scanVideos(rows)
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+	SELECT video_id, deleted_at
+	FROM video_tombstones
+	WHERE user_id = ?
+		AND (deleted_at > ? OR (deleted_at = ? AND video_id > ?))
+	ORDER BY deleted_at ASC, video_id ASC
+	LIMIT ?
+	`
+	rows, err = c.db.Query(query, userID, at, at, after.ID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	tombstones := []VideoTombstone{}
+	for rows.Next() {
+		var tombstone VideoTombstone
+		if err := rows.Scan(&tombstone.VideoID, &tombstone.DeletedAt); err != nil {
+			return nil, nil, err
+		}
+		tombstones = append(tombstones, tombstone)
+	}
+	return videos, tombstones, rows.Err()
+}
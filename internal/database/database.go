@@ -7,6 +7,10 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// timestampLayout matches how CURRENT_TIMESTAMP stores times. Times bound
+// against such columns must be UTC and in this layout to compare correctly.
+const timestampLayout = "2006-01-02 15:04:05"
+
 type Client struct {
 	db *sql.DB
 }
@@ -72,13 +76,336 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	idempotencyTable := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_path TEXT NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		response_body BLOB,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, key),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);
+	`
+	_, err = c.db.Exec(idempotencyTable)
+	if err != nil {
+		return err
+	}
+
+	// audit_log deliberately has no foreign keys so history outlives the
+	// videos and users it describes
+	auditTable := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor_id TEXT,
+		video_id TEXT NOT NULL,
+		action TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_video_id ON audit_log(video_id);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id);
+	`
+	_, err = c.db.Exec(auditTable)
+	if err != nil {
+		return err
+	}
+
+	sharesTable := `
+	CREATE TABLE IF NOT EXISTS video_shares (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		permission TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (video_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_shares_user_id ON video_shares(user_id);
+	`
+	_, err = c.db.Exec(sharesTable)
+	if err != nil {
+		return err
+	}
+
+	shareLinksTable := `
+	CREATE TABLE IF NOT EXISTS video_share_links (
+		token TEXT PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP,
+		password_hash TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_share_links_video_id ON video_share_links(video_id);
+	`
+	_, err = c.db.Exec(shareLinksTable)
+	if err != nil {
+		return err
+	}
+
+	audioTracksTable := `
+	CREATE TABLE IF NOT EXISTS video_audio_tracks (
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (video_id, language)
+	);
+	`
+	_, err = c.db.Exec(audioTracksTable)
+	if err != nil {
+		return err
+	}
+
+	multipartUploadsTable := `
+	CREATE TABLE IF NOT EXISTS video_multipart_uploads (
+		video_id TEXT PRIMARY KEY,
+		upload_id TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(multipartUploadsTable)
+	if err != nil {
+		return err
+	}
+
+	userSettingsTable := `
+	CREATE TABLE IF NOT EXISTS user_settings (
+		user_id TEXT PRIMARY KEY,
+		transcode_profile TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+	_, err = c.db.Exec(userSettingsTable)
+	if err != nil {
+		return err
+	}
+
+	// Only the latest time of each event is kept per user and video
+	activityTable := `
+	CREATE TABLE IF NOT EXISTS video_activity (
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, video_id, event)
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_activity_user_at ON video_activity(user_id, at);
+	`
+	_, err = c.db.Exec(activityTable)
+	if err != nil {
+		return err
+	}
+
+	// Deleted videos leave a tombstone so syncing clients learn about them
+	tombstonesTable := `
+	CREATE TABLE IF NOT EXISTS video_tombstones (
+		video_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_video_tombstones_user_deleted ON video_tombstones(user_id, deleted_at);
+	`
+	_, err = c.db.Exec(tombstonesTable)
+	if err != nil {
+		return err
+	}
+
+	added, err := c.addColumn("videos", "status", "TEXT NOT NULL DEFAULT 'draft'")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Rows that predate the column already have their file uploaded.
+		_, err = c.db.Exec("UPDATE videos SET status = 'ready' WHERE video_url IS NOT NULL")
+		if err != nil {
+			return err
+		}
+	}
+
+	// metadata holds an opaque, client-owned JSON object
+	if _, err := c.addColumn("videos", "metadata", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "size_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("users", "storage_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "processing_error", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "preview_url", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "hidden", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "archived", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "dash_url", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "public", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "duration_seconds", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("users", "tier", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "chapters", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "chapters_url", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "blurhash", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "download_only", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	added, err = c.addColumn("videos", "aspect_ratio", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Earlier uploads only recorded their aspect ratio in the key's orientation folder
+		backfill := `
+		UPDATE videos SET aspect_ratio = CASE
+			WHEN video_url LIKE '%/landscape/%' THEN '16:9'
+			WHEN video_url LIKE '%/portrait/%' THEN '9:16'
+			ELSE 'other'
+		END
+		WHERE video_url IS NOT NULL
+		`
+		if _, err := c.db.Exec(backfill); err != nil {
+			return err
+		}
+	}
+	if _, err := c.addColumn("videos", "dash_renditions", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "processing_skipped", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.addColumn("videos", "techinfo", "TEXT"); err != nil {
+		return err
+	}
+	added, err = c.addColumn("videos", "content_type", "TEXT")
+	if err != nil {
+		return err
+	}
+	if added {
+		// Every file stored before containers were configurable is mp4
+		if _, err := c.db.Exec("UPDATE videos SET content_type = 'video/mp4' WHERE video_url IS NOT NULL"); err != nil {
+			return err
+		}
+	}
+	for _, column := range []struct{ name, definition string }{
+		{"thumbnail_width", "INTEGER"},
+		{"thumbnail_height", "INTEGER"},
+		{"thumbnail_aspect", "TEXT"},
+		{"width", "INTEGER"},
+		{"height", "INTEGER"},
+		{"video_codec", "TEXT"},
+		{"audio_codec", "TEXT"},
+	} {
+		if _, err := c.addColumn("videos", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if _, err := c.addColumn("users", "quota_notified_percent", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("CREATE INDEX IF NOT EXISTS idx_videos_video_url ON videos(video_url)"); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("CREATE INDEX IF NOT EXISTS idx_videos_user_updated ON videos(user_id, updated_at)"); err != nil {
+		return err
+	}
 	return nil
 }
 
+// addColumn adds a column to an existing table unless it is already there,
+// reporting whether the column was created.
+func (c *Client) addColumn(table, column, definition string) (bool, error) {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
+
+// Ping checks that the database is reachable.
+func (c Client) Ping() error {
+	return c.db.Ping()
+}
+
 func (c Client) Reset() error {
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM idempotency_keys"); err != nil {
+		return fmt.Errorf("failed to reset table idempotency_keys: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM audit_log"); err != nil {
+		return fmt.Errorf("failed to reset table audit_log: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_shares"); err != nil {
+		return fmt.Errorf("failed to reset table video_shares: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_share_links"); err != nil {
+		return fmt.Errorf("failed to reset table video_share_links: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_audio_tracks"); err != nil {
+		return fmt.Errorf("failed to reset table video_audio_tracks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_multipart_uploads"); err != nil {
+		return fmt.Errorf("failed to reset table video_multipart_uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_tombstones"); err != nil {
+		return fmt.Errorf("failed to reset table video_tombstones: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_activity"); err != nil {
+		return fmt.Errorf("failed to reset table video_activity: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM user_settings"); err != nil {
+		return fmt.Errorf("failed to reset table user_settings: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKey remembers the outcome of a request so a retry with the same
+// key can be answered without running it again. StatusCode is 0 while the
+// original request is still in flight.
+type IdempotencyKey struct {
+	UserID       uuid.UUID
+	Key          string
+	RequestPath  string
+	StatusCode   int
+	ResponseBody []byte
+	CreatedAt    time.Time
+}
+
+// ClaimIdempotencyKey records a new in-flight key, reporting false if the
+// user already has a record for it.
+func (c Client) ClaimIdempotencyKey(userID uuid.UUID, key, requestPath string) (bool, error) {
+	query := `
+		INSERT OR IGNORE INTO idempotency_keys
+			(user_id, key, request_path, status_code, created_at)
+		VALUES
+			(?, ?, ?, 0, CURRENT_TIMESTAMP)
+	`
+	res, err := c.db.Exec(query, userID.String(), key, requestPath)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (c Client) GetIdempotencyKey(userID uuid.UUID, key string) (IdempotencyKey, error) {
+	query := `
+		SELECT request_path, status_code, response_body, created_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ?
+	`
+	record := IdempotencyKey{UserID: userID, Key: key}
+	err := c.db.QueryRow(query, userID.String(), key).
+		Scan(&record.RequestPath, &record.StatusCode, &record.ResponseBody, &record.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return IdempotencyKey{}, nil
+		}
+		return IdempotencyKey{}, err
+	}
+	return record, nil
+}
+
+func (c Client) CompleteIdempotencyKey(userID uuid.UUID, key string, statusCode int, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = ?, response_body = ?
+		WHERE user_id = ? AND key = ?
+	`
+	_, err := c.db.Exec(query, statusCode, body, userID.String(), key)
+	return err
+}
+
+func (c Client) DeleteIdempotencyKey(userID uuid.UUID, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = ? AND key = ?
+	`
+	_, err := c.db.Exec(query, userID.String(), key)
+	return err
+}
+
+func (c Client) DeleteExpiredIdempotencyKeys(before time.Time) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < ?
+	`
+	_, err := c.db.Exec(query, before)
+	return err
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MultipartUpload is an S3 multipart upload a client is sending a video's
+// file through directly. A video has at most one in progress.
+type MultipartUpload struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	UploadID  string    `json:"upload_id"`
+	Bucket    string    `json:"-"`
+	Key       string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateMultipartUploadParams struct {
+	VideoID  uuid.UUID
+	UploadID string
+	Bucket   string
+	Key      string
+}
+
+func (c Client) CreateMultipartUpload(params CreateMultipartUploadParams) (MultipartUpload, error) {
+	query := `
+		INSERT INTO video_multipart_uploads (video_id, upload_id, bucket, object_key, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	_, err := c.db.Exec(query, params.VideoID, params.UploadID, params.Bucket, params.Key)
+	if err != nil {
+		return MultipartUpload{}, err
+	}
+	return c.GetMultipartUpload(params.VideoID)
+}
+
+// GetMultipartUpload returns a video's multipart upload, or a zero upload
+// if there isn't one.
+func (c Client) GetMultipartUpload(videoID uuid.UUID) (MultipartUpload, error) {
+	query := `
+		SELECT video_id, upload_id, bucket, object_key, created_at
+		FROM video_multipart_uploads
+		WHERE video_id = ?
+	`
+	var upload MultipartUpload
+	err := c.db.QueryRow(query, videoID).Scan(&upload.VideoID, &upload.UploadID, &upload.Bucket, &upload.Key, &upload.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MultipartUpload{}, nil
+		}
+		return MultipartUpload{}, err
+	}
+	return upload, nil
+}
+
+// DeleteMultipartUpload forgets a video's multipart upload once it has been
+// completed or aborted.
+func (c Client) DeleteMultipartUpload(videoID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_multipart_uploads WHERE video_id = ?", videoID)
+	return err
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserSettings are a user's preferences. Unset preferences are nil and fall
+// back to the server's defaults.
+type UserSettings struct {
+	TranscodeProfile *string    `json:"transcode_profile"`
+	UpdatedAt        *time.Time `json:"updated_at"`
+}
+
+// GetUserSettings returns a user's settings, or empty settings if they've
+// never saved any.
+func (c Client) GetUserSettings(userID uuid.UUID) (UserSettings, error) {
+	query := `
+		SELECT transcode_profile, updated_at
+		FROM user_settings
+		WHERE user_id = ?
+	`
+	var settings UserSettings
+	var profile sql.NullString
+	var updatedAt time.Time
+	err := c.db.QueryRow(query, userID.String()).Scan(&profile, &updatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserSettings{}, nil
+		}
+		return UserSettings{}, err
+	}
+	if profile.Valid {
+		settings.TranscodeProfile = &profile.String
+	}
+	settings.UpdatedAt = &updatedAt
+	return settings, nil
+}
+
+// SetUserSettings replaces a user's settings.
+func (c Client) SetUserSettings(userID uuid.UUID, settings UserSettings) error {
+	query := `
+		INSERT INTO user_settings (user_id, transcode_profile, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			transcode_profile = excluded.transcode_profile,
+			updated_at = CURRENT_TIMESTAMP
+	`
+	_, err := c.db.Exec(query, userID.String(), settings.TranscodeProfile)
+	return err
+}
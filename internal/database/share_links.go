@@ -0,0 +1,112 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink lets anyone holding its token watch a video without an
+// account, optionally behind a password and until it expires.
+type ShareLink struct {
+	Token        string     `json:"token"`
+	VideoID      uuid.UUID  `json:"video_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	PasswordHash *string    `json:"-"`
+}
+
+type CreateShareLinkParams struct {
+	Token        string
+	VideoID      uuid.UUID
+	ExpiresAt    *time.Time
+	PasswordHash *string
+}
+
+func (c Client) CreateShareLink(params CreateShareLinkParams) (ShareLink, error) {
+	query := `
+		INSERT INTO video_share_links (token, video_id, created_at, expires_at, password_hash)
+		VALUES (?, ?, CURRENT_TIMESTAMP, ?, ?)
+	`
+	_, err := c.db.Exec(query, params.Token, params.VideoID, params.ExpiresAt, params.PasswordHash)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	return c.GetShareLink(params.Token)
+}
+
+// GetShareLink returns the link with the given token, or a zero link if
+// there isn't one. Expired links are returned; callers check ExpiresAt.
+func (c Client) GetShareLink(token string) (ShareLink, error) {
+	query := `
+		SELECT token, video_id, created_at, expires_at, password_hash
+		FROM video_share_links
+		WHERE token = ?
+	`
+	link, err := scanShareLink(c.db.QueryRow(query, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ShareLink{}, nil
+		}
+		return ShareLink{}, err
+	}
+	return link, nil
+}
+
+// GetShareLinks lists a video's share links, oldest first.
+func (c Client) GetShareLinks(videoID uuid.UUID) ([]ShareLink, error) {
+	query := `
+		SELECT token, video_id, created_at, expires_at, password_hash
+		FROM video_share_links
+		WHERE video_id = ?
+		ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// DeleteShareLink revokes one of a video's links, reporting whether it
+// existed.
+func (c Client) DeleteShareLink(videoID uuid.UUID, token string) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM video_share_links WHERE video_id = ? AND token = ?", videoID, token)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func scanShareLink(row rowScanner) (ShareLink, error) {
+	var link ShareLink
+	var expiresAt sql.NullTime
+	var passwordHash sql.NullString
+	err := row.Scan(&link.Token, &link.VideoID, &link.CreatedAt, &expiresAt, &passwordHash)
+	if err != nil {
+		return ShareLink{}, err
+	}
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if passwordHash.Valid {
+		link.PasswordHash = &passwordHash.String
+	}
+	return link, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SharePermission string
+
+const (
+	SharePermissionRead SharePermission = "read"
+	SharePermissionEdit SharePermission = "edit"
+)
+
+// VideoShare grants a user other than the owner access to a video.
+type VideoShare struct {
+	VideoID    uuid.UUID       `json:"video_id"`
+	UserID     uuid.UUID       `json:"user_id"`
+	Permission SharePermission `json:"permission"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// ShareVideo grants access, replacing the permission of an existing share.
+func (c Client) ShareVideo(videoID, userID uuid.UUID, permission SharePermission) (VideoShare, error) {
+	query := `
+		INSERT INTO video_shares (video_id, user_id, permission, created_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (video_id, user_id) DO UPDATE SET permission = excluded.permission
+	`
+	if _, err := c.db.Exec(query, videoID, userID, permission); err != nil {
+		return VideoShare{}, err
+	}
+	return c.GetVideoShare(videoID, userID)
+}
+
+// GetVideoShare returns the user's share of the video, or a zero share if
+// there isn't one.
+func (c Client) GetVideoShare(videoID, userID uuid.UUID) (VideoShare, error) {
+	query := `
+		SELECT video_id, user_id, permission, created_at
+		FROM video_shares
+		WHERE video_id = ? AND user_id = ?
+	`
+	var share VideoShare
+	err := c.db.QueryRow(query, videoID, userID).
+		Scan(&share.VideoID, &share.UserID, &share.Permission, &share.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return VideoShare{}, nil
+		}
+		return VideoShare{}, err
+	}
+	return share, nil
+}
+
+// GetVideoShares lists everyone a video is shared with.
+func (c Client) GetVideoShares(videoID uuid.UUID) ([]VideoShare, error) {
+	query := `
+		SELECT video_id, user_id, permission, created_at
+		FROM video_shares
+		WHERE video_id = ?
+		ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []VideoShare{}
+	for rows.Next() {
+		var share VideoShare
+		if err := rows.Scan(&share.VideoID, &share.UserID, &share.Permission, &share.CreatedAt); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// RevokeVideoShare removes a share, reporting whether there was one.
+func (c Client) RevokeVideoShare(videoID, userID uuid.UUID) (bool, error) {
+	res, err := c.db.Exec("DELETE FROM video_shares WHERE video_id = ? AND user_id = ?", videoID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetVideosSharedWith lists the videos other users have shared with userID,
+// most recently shared first.
+func (c Client) GetVideosSharedWith(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id IN (SELECT video_id FROM video_shares WHERE user_id = ?)
+	ORDER BY (SELECT created_at FROM video_shares WHERE video_id = videos.id AND user_id = ?) DESC
+	`
+
+	rows, err := c.db.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UsageGroup totals a slice of a user's videos.
+type UsageGroup struct {
+	Key    string `json:"key"`
+	Videos int    `json:"videos"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// UsageSummary is a user's storage footprint across their videos.
+type UsageSummary struct {
+	Videos  int   `json:"videos"`
+	Objects int   `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// RecentViews counts viewers of the videos over the last 30 days, one
+	// per viewer and video since only the latest view of each is kept
+	RecentViews int `json:"recent_views"`
+	// EstimatedMonthlyBandwidthBytes is each of those views times the size
+	// of the video viewed: a floor, as repeat views and range requests
+	// aren't known
+	EstimatedMonthlyBandwidthBytes int64 `json:"estimated_monthly_bandwidth_bytes"`
+
+	ByStatus []UsageGroup `json:"by_status"`
+	ByPrefix []UsageGroup `json:"by_prefix"`
+}
+
+// GetUsageSummary aggregates a user's videos: totals, a breakdown by
+// status, and a breakdown by the first segment of the video file's key
+// (the orientation folder under the default key template). Objects counts
+// the files, thumbnails, previews, DASH manifests and chapter tracks the
+// videos reference. Bandwidth is estimated from the views recorded in
+// video_activity.
+func (c Client) GetUsageSummary(userID uuid.UUID) (UsageSummary, error) {
+	summary := UsageSummary{ByStatus: []UsageGroup{}, ByPrefix: []UsageGroup{}}
+
+	query := `
+	SELECT
+		COUNT(*),
+		COALESCE(SUM(size_bytes), 0),
+		COALESCE(SUM(
+			(video_url IS NOT NULL) + (thumbnail_url IS NOT NULL) + (preview_url IS NOT NULL) +
+			(dash_url IS NOT NULL) + (chapters_url IS NOT NULL)
+		), 0)
+	FROM videos
+	WHERE user_id = ?
+	`
+	err := c.db.QueryRow(query, userID).Scan(&summary.Videos, &summary.Bytes, &summary.Objects)
+	if err != nil {
+		return UsageSummary{}, err
+	}
+
+	query = `
+	SELECT COUNT(*), COALESCE(SUM(v.size_bytes), 0)
+	FROM video_activity a
+	JOIN videos v ON v.id = a.video_id
+	WHERE v.user_id = ? AND a.event = ? AND a.at >= ?
+	`
+	since := time.Now().UTC().AddDate(0, 0, -30)
+	err = c.db.QueryRow(query, userID, ActivityView, since).Scan(&summary.RecentViews, &summary.EstimatedMonthlyBandwidthBytes)
+	if err != nil {
+		return UsageSummary{}, err
+	}
+
+	summary.ByStatus, err = c.usageGroups(`
+	SELECT status, COUNT(*), COALESCE(SUM(size_bytes), 0)
+	FROM videos
+	WHERE user_id = ?
+	GROUP BY status
+	ORDER BY status
+	`, userID)
+	if err != nil {
+		return UsageSummary{}, err
+	}
+
+	// Peel the scheme and host off the URL, then take the key up to its first slash
+	summary.ByPrefix, err = c.usageGroups(`
+	WITH keys AS (
+		SELECT
+			size_bytes,
+			substr(rest, instr(rest, '/') + 1) AS key
+		FROM (
+			SELECT size_bytes, substr(video_url, instr(video_url, '://') + 3) AS rest
+			FROM videos
+			WHERE user_id = ? AND video_url IS NOT NULL
+		)
+	)
+	SELECT
+		CASE WHEN instr(key, '/') > 0 THEN substr(key, 1, instr(key, '/') - 1) ELSE '' END AS prefix,
+		COUNT(*),
+		COALESCE(SUM(size_bytes), 0)
+	FROM keys
+	GROUP BY prefix
+	ORDER BY prefix
+	`, userID)
+	if err != nil {
+		return UsageSummary{}, err
+	}
+	return summary, nil
+}
+
+func (c Client) usageGroups(query string, args ...interface{}) ([]UsageGroup, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []UsageGroup{}
+	for rows.Next() {
+		var group UsageGroup
+		if err := rows.Scan(&group.Key, &group.Videos, &group.Bytes); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
@@ -9,9 +9,11 @@ import (
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	StorageBytes int64     `json:"storage_bytes"`
+	Tier         string    `json:"tier"`
 	CreateUserParams
 }
 
@@ -24,7 +26,8 @@ func (c Client) GetUsers() ([]User, error) {
 	query := `
 		SELECT
 			id,
-			email
+			email,
+			storage_bytes
 		FROM users
 	`
 
@@ -38,7 +41,7 @@ func (c Client) GetUsers() ([]User, error) {
 	for rows.Next() {
 		var user User
 		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
+		if err := rows.Scan(&id, &user.Email, &user.StorageBytes); err != nil {
 			return nil, err
 		}
 		user.ID, err = uuid.Parse(id)
@@ -53,13 +56,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, storage_bytes
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.StorageBytes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,7 +78,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.storage_bytes
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,7 +86,7 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.StorageBytes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,13 +120,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, storage_bytes, tier
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.StorageBytes, &user.Tier)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,11 +140,83 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+// SetUserTier sets the queue tier a user's transcodes run in.
+func (c Client) SetUserTier(id uuid.UUID, tier string) error {
+	query := `
+		UPDATE users
+		SET tier = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, tier, id.String())
+	return err
+}
+
+// AddUserStorageBytes adjusts a user's storage usage counter by delta bytes.
+func (c Client) AddUserStorageBytes(id uuid.UUID, delta int64) error {
+	query := `
+		UPDATE users
+		SET storage_bytes = MAX(storage_bytes + ?, 0)
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, delta, id.String())
+	return err
+}
+
+// SetUserStorageBytes overwrites a user's storage usage counter.
+func (c Client) SetUserStorageBytes(id uuid.UUID, total int64) error {
+	query := `
+		UPDATE users
+		SET storage_bytes = ?
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, total, id.String())
+	return err
+}
+
+// GetQuotaNotified returns the highest storage quota threshold, as a
+// percentage, the user has been notified of crossing; 0 if none.
+func (c Client) GetQuotaNotified(id uuid.UUID) (int, error) {
+	query := `
+		SELECT quota_notified_percent
+		FROM users
+		WHERE id = ?
+	`
+	var percent int
+	err := c.db.QueryRow(query, id.String()).Scan(&percent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return percent, err
+}
+
+// SwapQuotaNotified moves the user's notified threshold from old to new,
+// reporting false if it was no longer old. Of several callers seeing the
+// same crossing, only one wins.
+func (c Client) SwapQuotaNotified(id uuid.UUID, old, new int) (bool, error) {
+	query := `
+		UPDATE users
+		SET quota_notified_percent = ?
+		WHERE id = ? AND quota_notified_percent = ?
+	`
+	result, err := c.db.Exec(query, new, id.String(), old)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	if _, err := c.db.Exec(query, id.String()); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM user_settings WHERE user_id = ?", id.String()); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM video_activity WHERE user_id = ?", id.String())
 	return err
 }
@@ -2,30 +2,133 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type VideoStatus string
+
+const (
+	VideoStatusDraft VideoStatus = "draft"
+	// VideoStatusUploading is a video whose file is still being received
+	VideoStatusUploading  VideoStatus = "uploading"
+	VideoStatusProcessing VideoStatus = "processing"
+	VideoStatusReady      VideoStatus = "ready"
+	VideoStatusFailed     VideoStatus = "failed"
+)
+
+// Busy reports whether a file is on its way into the video, so another
+// upload or import can't start.
+func (s VideoStatus) Busy() bool {
+	return s == VideoStatusUploading || s == VideoStatusProcessing
+}
+
+// Video is the single shape every endpoint returns a video in: create,
+// get and the listings all serialize it the same way. Every key is always
+// present. URL fields are null until the asset exists (a fresh draft has no
+// video_url or thumbnail_url), processing_error is null unless status is
+// "failed", and blurhash and aspect_ratio are null until they're known.
+// Chapters is an empty list rather than null. processing_skipped is true
+// when the file was stored exactly as uploaded, without remux or re-encode.
+// content_type is the MIME type of the file at video_url, null without one.
+// The thumbnail_ size fields are null until a thumbnail with a known size
+// is set. width, height, video_codec and audio_codec describe the file at
+// video_url and are null until it's been probed; audio_codec stays null for
+// files without audio.
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID                uuid.UUID   `json:"id"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+	ThumbnailURL      *string     `json:"thumbnail_url"`
+	VideoURL          *string     `json:"video_url"`
+	PreviewURL        *string     `json:"preview_url"`
+	DashURL           *string     `json:"dash_url"`
+	DashRenditions    []Rendition `json:"dash_renditions"`
+	Status            VideoStatus `json:"status"`
+	ProcessingError   *string     `json:"processing_error"`
+	SizeBytes         int64       `json:"size_bytes"`
+	Hidden            bool        `json:"hidden"`
+	Archived          bool        `json:"archived"`
+	Public            bool        `json:"public"`
+	DurationSeconds   float64     `json:"duration_seconds"`
+	Chapters          []Chapter   `json:"chapters"`
+	ChaptersURL       *string     `json:"chapters_url"`
+	BlurHash          *string     `json:"blurhash"`
+	DownloadOnly      bool        `json:"download_only"`
+	AspectRatio       *string     `json:"aspect_ratio"`
+	ProcessingSkipped bool        `json:"processing_skipped"`
+	ContentType       *string     `json:"content_type"`
+	ThumbnailWidth    *int        `json:"thumbnail_width"`
+	ThumbnailHeight   *int        `json:"thumbnail_height"`
+	ThumbnailAspect   *string     `json:"thumbnail_aspect"`
+	Width             *int        `json:"width"`
+	Height            *int        `json:"height"`
+	VideoCodec        *string     `json:"video_codec"`
+	AudioCodec        *string     `json:"audio_codec"`
 	CreateVideoParams
 }
 
+// ThumbnailSize is a thumbnail image's size in pixels and its aspect ratio
+// bucket: "16:9", "9:16" or "other".
+type ThumbnailSize struct {
+	Width  int
+	Height int
+	Aspect string
+}
+
+// ThumbnailSize returns the recorded size of the video's thumbnail, or nil
+// when it isn't known.
+func (v Video) ThumbnailSize() *ThumbnailSize {
+	if v.ThumbnailWidth == nil || v.ThumbnailHeight == nil || v.ThumbnailAspect == nil {
+		return nil
+	}
+	return &ThumbnailSize{Width: *v.ThumbnailWidth, Height: *v.ThumbnailHeight, Aspect: *v.ThumbnailAspect}
+}
+
+// MediaInfo is what probing a video's file found: its first video stream's
+// size and codec, and its first audio stream's codec, nil without audio.
+type MediaInfo struct {
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec *string
+}
+
+// MediaInfo returns what's recorded about the video's file, or nil when it
+// hasn't been probed.
+func (v Video) MediaInfo() *MediaInfo {
+	if v.Width == nil || v.Height == nil || v.VideoCodec == nil {
+		return nil
+	}
+	return &MediaInfo{Width: *v.Width, Height: *v.Height, VideoCodec: *v.VideoCodec, AudioCodec: v.AudioCodec}
+}
+
+// Rendition is one quality level of a video's DASH packaging. Height is the
+// shorter side in pixels and Bitrate the target video bitrate in bits per
+// second, so adaptive players can choose by bandwidth.
+type Rendition struct {
+	Height  int `json:"height"`
+	Bitrate int `json:"bitrate"`
+}
+
+// Chapter marks where a titled section of a video starts, in seconds.
+type Chapter struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start"`
+}
+
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	UserID      uuid.UUID       `json:"user_id"`
+	Metadata    json.RawMessage `json:"metadata"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,7 +136,107 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
+		user_id,
+		status,
+		metadata,
+		size_bytes,
+		processing_error,
+		preview_url,
+		hidden,
+		archived,
+		dash_url,
+		public,
+		duration_seconds,
+		chapters,
+		chapters_url,
+		blurhash,
+		download_only,
+		aspect_ratio,
+		dash_renditions,
+		processing_skipped,
+		content_type,
+		thumbnail_width,
+		thumbnail_height,
+		thumbnail_aspect,
+		width,
+		height,
+		video_codec,
+		audio_codec`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	var metadata, chapters, renditions sql.NullString
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.UserID,
+		&video.Status,
+		&metadata,
+		&video.SizeBytes,
+		&video.ProcessingError,
+		&video.PreviewURL,
+		&video.Hidden,
+		&video.Archived,
+		&video.DashURL,
+		&video.Public,
+		&video.DurationSeconds,
+		&chapters,
+		&video.ChaptersURL,
+		&video.BlurHash,
+		&video.DownloadOnly,
+		&video.AspectRatio,
+		&renditions,
+		&video.ProcessingSkipped,
+		&video.ContentType,
+		&video.ThumbnailWidth,
+		&video.ThumbnailHeight,
+		&video.ThumbnailAspect,
+		&video.Width,
+		&video.Height,
+		&video.VideoCodec,
+		&video.AudioCodec,
+	)
+	if err != nil {
+		return video, err
+	}
+	if metadata.Valid {
+		video.Metadata = json.RawMessage(metadata.String)
+	}
+	video.Chapters = []Chapter{}
+	if chapters.Valid {
+		if err := json.Unmarshal([]byte(chapters.String), &video.Chapters); err != nil {
+			return video, err
+		}
+	}
+	video.DashRenditions = []Rendition{}
+	if renditions.Valid {
+		if err := json.Unmarshal([]byte(renditions.String), &video.DashRenditions); err != nil {
+			return video, err
+		}
+	}
+	return video, nil
+}
+
+// nullableJSON stores an empty or null blob as NULL and anything else as text.
+func nullableJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return string(raw)
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
 	ORDER BY created_at DESC
@@ -45,25 +248,255 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 	}
 	defer rows.Close()
 
+	return scanVideos(rows)
+}
+
+// VideoSort names an allowed ordering for ListVideos.
+type VideoSort string
+
+const (
+	VideoSortNewest  VideoSort = "newest"
+	VideoSortOldest  VideoSort = "oldest"
+	VideoSortUpdated VideoSort = "updated"
+	VideoSortTitle   VideoSort = "title"
+	// Field-style names for the creation-time orders
+	VideoSortCreatedAsc  VideoSort = "created_at"
+	VideoSortCreatedDesc VideoSort = "-created_at"
+)
+
+var videoSortClauses = map[VideoSort]string{
+	VideoSortNewest:  "created_at DESC",
+	VideoSortOldest:  "created_at ASC",
+	VideoSortUpdated: "updated_at DESC",
+	VideoSortTitle:   "title COLLATE NOCASE ASC, created_at DESC",
+
+	VideoSortCreatedAsc:  "created_at ASC",
+	VideoSortCreatedDesc: "created_at DESC",
+}
+
+// ValidVideoSort reports whether ListVideos supports the sort.
+func ValidVideoSort(sort VideoSort) bool {
+	_, ok := videoSortClauses[sort]
+	return ok
+}
+
+type ListVideosParams struct {
+	UserID          uuid.UUID
+	IncludeHidden   bool
+	IncludeArchived bool
+	Sort            VideoSort
+	// Limit of 0 returns every matching video
+	Limit  int
+	Offset int
+}
+
+// where is the filter ListVideos and GetVideosPaged apply.
+func (params ListVideosParams) where() (string, []interface{}) {
+	clause := " WHERE user_id = ?"
+	if !params.IncludeHidden {
+		clause += " AND hidden = 0"
+	}
+	if !params.IncludeArchived {
+		clause += " AND archived = 0"
+	}
+	return clause, []interface{}{params.UserID}
+}
+
+// ListVideos returns a user's videos, leaving out hidden and archived ones
+// unless asked for them.
+func (c Client) ListVideos(params ListVideosParams) ([]Video, error) {
+	orderBy, ok := videoSortClauses[params.Sort]
+	if !ok {
+		orderBy = videoSortClauses[VideoSortNewest]
+	}
+
+	where, args := params.where()
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos` + where
+	query += " ORDER BY " + orderBy
+	if params.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, params.Limit, params.Offset)
+	}
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
+
+// GetVideosPaged returns one page of ListVideos along with how many videos
+// match in all.
+func (c Client) GetVideosPaged(params ListVideosParams) ([]Video, int, error) {
+	where, args := params.where()
+	var total int
+	if err := c.db.QueryRow("SELECT COUNT(*) FROM videos"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	videos, err := c.ListVideos(params)
+	if err != nil {
+		return nil, 0, err
+	}
+	return videos, total, nil
+}
+
+// GetPublicVideos returns a user's public, ready videos, newest first.
+// Hidden and archived videos are left out even when marked public.
+func (c Client) GetPublicVideos(userID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ?
+		AND public = 1
+		AND hidden = 0
+		AND archived = 0
+		AND status = ?
+		AND video_url IS NOT NULL
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, userID, VideoStatusReady, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
+
+// UnknownAspectRatio groups videos whose file hasn't been processed yet.
+const UnknownAspectRatio = "unknown"
+
+// AspectGroup is one aspect ratio's page of videos, newest first, and how
+// many videos have that ratio in all.
+type AspectGroup struct {
+	Total  int     `json:"total"`
+	Videos []Video `json:"videos"`
+}
+
+// GetVideosByAspect pages through a user's listed videos separately for each
+// aspect ratio, returning up to limit per ratio after skipping offset.
+// Hidden and archived videos are left out, as in the default listing.
+func (c Client) GetVideosByAspect(userID uuid.UUID, limit, offset int) (map[string]AspectGroup, error) {
+	groups := map[string]AspectGroup{}
+
+	totals, err := c.db.Query(`
+	SELECT COALESCE(aspect_ratio, ?), COUNT(*)
+	FROM videos
+	WHERE user_id = ? AND hidden = 0 AND archived = 0
+	GROUP BY 1
+	`, UnknownAspectRatio, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer totals.Close()
+	for totals.Next() {
+		var aspect string
+		var total int
+		if err := totals.Scan(&aspect, &total); err != nil {
+			return nil, err
+		}
+		groups[aspect] = AspectGroup{Total: total, Videos: []Video{}}
+	}
+	if err := totals.Err(); err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM (
+		SELECT *, ROW_NUMBER() OVER (
+			PARTITION BY COALESCE(aspect_ratio, ?)
+			ORDER BY created_at DESC, id
+		) AS position
+		FROM videos
+		WHERE user_id = ? AND hidden = 0 AND archived = 0
+	)
+	WHERE position > ? AND position <= ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.db.Query(query, UnknownAspectRatio, userID, offset, offset+limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, video := range videos {
+		aspect := UnknownAspectRatio
+		if video.AspectRatio != nil {
+			aspect = *video.AspectRatio
+		}
+		group := groups[aspect]
+		group.Videos = append(group.Videos, video)
+		groups[aspect] = group
+	}
+	return groups, nil
+}
+
+// GetVideosByIDs returns the videos with the given IDs, skipping any that
+// don't exist.
+func (c Client) GetVideosByIDs(ids []uuid.UUID) ([]Video, error) {
+	if len(ids) == 0 {
+		return []Video{}, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id IN (` + placeholders + `)
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanVideos(rows)
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
+
+// GetFailedVideos returns videos that were marked failed before the cutoff.
+func (c Client) GetFailedVideos(before time.Time) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE status = ? AND updated_at < ?
+	ORDER BY updated_at ASC
+	`
+
+	rows, err := c.db.Query(query, VideoStatusFailed, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return videos, nil
+	return scanVideos(rows)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +508,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		status,
+		metadata
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, VideoStatusDraft, nullableJSON(params.Metadata))
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +523,33 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+
+	return video, nil
+}
+
+// GetVideoByStorageKey finds the video whose stored object URL matches.
+// Videos reference their object by URL, so callers resolve bucket and key
+// into that URL first.
+func (c Client) GetVideoByStorageKey(objectURL string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE video_url = ?
+	`
+
+	video, err := scanVideo(c.db.QueryRow(query, objectURL))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +560,422 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// GetStoredURLs returns every object URL a video references: files,
+// thumbnails, previews, DASH manifests, chapter tracks and audio tracks.
+func (c Client) GetStoredURLs() ([]string, error) {
+	query := `
+	SELECT video_url, thumbnail_url, preview_url, dash_url, chapters_url
+	FROM videos
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := []string{}
+	for rows.Next() {
+		var videoURL, thumbnailURL, previewURL, dashURL, chaptersURL sql.NullString
+		if err := rows.Scan(&videoURL, &thumbnailURL, &previewURL, &dashURL, &chaptersURL); err != nil {
+			return nil, err
+		}
+		for _, u := range []sql.NullString{videoURL, thumbnailURL, previewURL, dashURL, chaptersURL} {
+			if u.Valid {
+				urls = append(urls, u.String)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	trackRows, err := c.db.Query("SELECT url FROM video_audio_tracks")
+	if err != nil {
+		return nil, err
+	}
+	defer trackRows.Close()
+	for trackRows.Next() {
+		var u string
+		if err := trackRows.Scan(&u); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, trackRows.Err()
+}
+
+// The Set* methods below each write only their own columns (and bump
+// updated_at), so concurrent updates to different fields don't overwrite
+// one another the way a full-row update would.
+
+func (c Client) SetStatus(id uuid.UUID, status VideoStatus) error {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		processing_error = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, id)
+	return err
+}
+
+// SwapStatus moves a video from status old to new, reporting false if it
+// was no longer old. Of several requests seeing the same status, only one
+// wins. Unlike SetStatus it keeps any processing error.
+func (c Client) SwapStatus(id uuid.UUID, old, new VideoStatus) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND status = ?
+	`
+	result, err := c.db.Exec(query, new, id, old)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// FailVideo marks a video failed and records why.
+func (c Client) FailVideo(id uuid.UUID, reason string) error {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		processing_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, VideoStatusFailed, reason, id)
+	return err
+}
+
+// FailInterruptedVideos marks every video still uploading or processing as
+// failed with reason, and returns how many there were. It's for startup,
+// when nothing can still be working on them.
+func (c Client) FailInterruptedVideos(reason string) (int64, error) {
+	query := `
+	UPDATE videos
+	SET
+		status = ?,
+		processing_error = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE status IN (?, ?)
+	`
+	result, err := c.db.Exec(query, VideoStatusFailed, reason, VideoStatusUploading, VideoStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetVideoURL points the video at a stored object of the given size, or
+// clears it when videoURL is nil. Any cached technical summary, media info
+// and content type of the old file are dropped.
+func (c Client) SetVideoURL(id uuid.UUID, videoURL *string, sizeBytes int64) error {
+	query := `
+	UPDATE videos
+	SET
+		video_url = ?,
+		size_bytes = ?,
+		techinfo = NULL,
+		content_type = NULL,
+		width = NULL,
+		height = NULL,
+		video_codec = NULL,
+		audio_codec = NULL,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, videoURL, sizeBytes, id)
+	return err
+}
+
+// GetTechInfo returns the technical summary cached for a video's file, or
+// nil when none has been computed since the file last changed.
+func (c Client) GetTechInfo(id uuid.UUID) (json.RawMessage, error) {
+	query := `
+	SELECT techinfo
+	FROM videos
+	WHERE id = ?
+	`
+	var info sql.NullString
+	err := c.db.QueryRow(query, id).Scan(&info)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.Valid {
+		return nil, nil
+	}
+	return json.RawMessage(info.String), nil
+}
+
+// SetTechInfo caches the technical summary of a video's file. SetVideoURL
+// clears it, so it is never stale.
+func (c Client) SetTechInfo(id uuid.UUID, info json.RawMessage) error {
+	query := `
+	UPDATE videos
+	SET
+		techinfo = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, nullableJSON(info), id)
+	return err
+}
+
+// SetThumbnailURL replaces the thumbnail along with its BlurHash
+// placeholder and size, each nil when it couldn't be worked out.
+func (c Client) SetThumbnailURL(id uuid.UUID, thumbnailURL string, blurHash *string, size *ThumbnailSize) error {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_url = ?,
+		blurhash = ?,
+		thumbnail_width = ?,
+		thumbnail_height = ?,
+		thumbnail_aspect = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	var width, height, aspect interface{}
+	if size != nil {
+		width, height, aspect = size.Width, size.Height, size.Aspect
+	}
+	_, err := c.db.Exec(query, thumbnailURL, blurHash, width, height, aspect, id)
+	return err
+}
+
+// SetPreviewURL records the animated preview, or clears it when previewURL is nil.
+func (c Client) SetPreviewURL(id uuid.UUID, previewURL *string) error {
+	query := `
+	UPDATE videos
+	SET
+		preview_url = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, previewURL, id)
+	return err
+}
+
+// SetDashURL records the DASH manifest and the renditions it offers, or
+// clears both when dashURL is nil.
+func (c Client) SetDashURL(id uuid.UUID, dashURL *string, renditions []Rendition) error {
+	var encoded interface{}
+	if dashURL != nil && len(renditions) > 0 {
+		dat, err := json.Marshal(renditions)
+		if err != nil {
+			return err
+		}
+		encoded = string(dat)
+	}
+	query := `
+	UPDATE videos
+	SET
+		dash_url = ?,
+		dash_renditions = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, dashURL, encoded, id)
+	return err
+}
+
+func (c Client) SetMetadataFields(id uuid.UUID, title, description string) error {
 	query := `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
-		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
+	_, err := c.db.Exec(query, title, description, id)
+	return err
+}
 
-	_, err := c.db.Exec(
-		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		video.UserID,
-		video.ID,
-	)
+// SetAspectRatio records the aspect ratio label ("16:9", "9:16" or
+// "other") of a video's processed file.
+func (c Client) SetAspectRatio(id uuid.UUID, aspectRatio string) error {
+	query := `
+	UPDATE videos
+	SET
+		aspect_ratio = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, aspectRatio, id)
+	return err
+}
+
+// SetProcessingSkipped records whether the video's file was stored as
+// uploaded, without being remuxed or re-encoded.
+func (c Client) SetProcessingSkipped(id uuid.UUID, skipped bool) error {
+	query := `
+	UPDATE videos
+	SET
+		processing_skipped = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, skipped, id)
+	return err
+}
+
+// SetMediaInfo records what probing the video's file found.
+func (c Client) SetMediaInfo(id uuid.UUID, info MediaInfo) error {
+	query := `
+	UPDATE videos
+	SET
+		width = ?,
+		height = ?,
+		video_codec = ?,
+		audio_codec = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, info.Width, info.Height, info.VideoCodec, info.AudioCodec, id)
+	return err
+}
+
+// SetContentType records the MIME type of the video's stored file.
+func (c Client) SetContentType(id uuid.UUID, contentType string) error {
+	query := `
+	UPDATE videos
+	SET
+		content_type = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, contentType, id)
+	return err
+}
+
+// SetDownloadOnly sets whether the video file is served as a download
+// rather than for playing in place.
+func (c Client) SetDownloadOnly(id uuid.UUID, downloadOnly bool) error {
+	query := `
+	UPDATE videos
+	SET
+		download_only = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, downloadOnly, id)
+	return err
+}
+
+// SetFlags updates whether a video is hidden from and archived out of the
+// default library listing.
+func (c Client) SetFlags(id uuid.UUID, hidden, archived, public bool) error {
+	query := `
+	UPDATE videos
+	SET
+		hidden = ?,
+		archived = ?,
+		public = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, hidden, archived, public, id)
+	return err
+}
+
+func (c Client) SetDuration(id uuid.UUID, seconds float64) error {
+	query := `
+	UPDATE videos
+	SET
+		duration_seconds = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, seconds, id)
+	return err
+}
+
+// SetChapters replaces a video's chapters and the URL of their WebVTT track.
+func (c Client) SetChapters(id uuid.UUID, chapters []Chapter, chaptersURL *string) error {
+	var encoded interface{}
+	if len(chapters) > 0 {
+		dat, err := json.Marshal(chapters)
+		if err != nil {
+			return err
+		}
+		encoded = string(dat)
+	}
+	query := `
+	UPDATE videos
+	SET
+		chapters = ?,
+		chapters_url = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, encoded, chaptersURL, id)
+	return err
+}
+
+func (c Client) SetMetadata(id uuid.UUID, metadata json.RawMessage) error {
+	query := `
+	UPDATE videos
+	SET
+		metadata = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, nullableJSON(metadata), id)
+	return err
+}
+
+// SetVideoSize corrects the recorded object size.
+func (c Client) SetVideoSize(id uuid.UUID, size int64) error {
+	query := `
+	UPDATE videos
+	SET
+		size_bytes = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, size, id)
 	return err
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	tombstone := `
+	INSERT OR REPLACE INTO video_tombstones (video_id, user_id, deleted_at)
+	SELECT id, user_id, CURRENT_TIMESTAMP FROM videos WHERE id = ?
+	`
+	if _, err := c.db.Exec(tombstone, id); err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	if _, err := c.db.Exec(query, id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_shares WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_share_links WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_audio_tracks WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_activity WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM video_multipart_uploads WHERE video_id = ?", id)
 	return err
 }
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestClient(t *testing.T) Client {
+	t.Helper()
+	c, err := NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	// One connection so concurrent writers queue instead of hitting SQLITE_BUSY
+	c.db.SetMaxOpenConns(1)
+	t.Cleanup(func() { c.db.Close() })
+	return c
+}
+
+func newTestVideo(t *testing.T, c Client) Video {
+	t.Helper()
+	user, err := c.CreateUser(CreateUserParams{Email: "race@example.com", Password: "x"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	video, err := c.CreateVideo(CreateVideoParams{Title: "original", Description: "original", UserID: user.ID})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+// TestSetThumbnailURLKeepsMetadata checks that writing the thumbnail
+// leaves an earlier metadata edit alone. The race itself is exercised by
+// TestSetThumbnailURLConcurrentMetadataEdits.
+func TestSetThumbnailURLKeepsMetadata(t *testing.T) {
+	c := newTestClient(t)
+	video := newTestVideo(t, c)
+
+	if err := c.SetMetadataFields(video.ID, "edited", "edited description"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetThumbnailURL(video.ID, "https://cdn.example.com/thumb.png", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "edited" || got.Description != "edited description" {
+		t.Errorf("metadata = %q, %q; the edit was lost", got.Title, got.Description)
+	}
+	if got.ThumbnailURL == nil || *got.ThumbnailURL != "https://cdn.example.com/thumb.png" {
+		t.Errorf("thumbnail_url = %v", got.ThumbnailURL)
+	}
+}
+
+// TestSetThumbnailURLConcurrentMetadataEdits interleaves thumbnail and
+// metadata writes; neither may undo the other.
+func TestSetThumbnailURLConcurrentMetadataEdits(t *testing.T) {
+	c := newTestClient(t)
+	video := newTestVideo(t, c)
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*rounds)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			errs <- c.SetThumbnailURL(video.ID, fmt.Sprintf("https://cdn.example.com/%d.png", i), nil, nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			errs <- c.SetMetadataFields(video.ID, fmt.Sprintf("title %d", i), "description")
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := c.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("title %d", rounds-1); got.Title != want {
+		t.Errorf("title = %q, want the last edit %q", got.Title, want)
+	}
+	if want := fmt.Sprintf("https://cdn.example.com/%d.png", rounds-1); got.ThumbnailURL == nil || *got.ThumbnailURL != want {
+		t.Errorf("thumbnail_url = %v, want the last upload %q", got.ThumbnailURL, want)
+	}
+}
@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// DefaultTimeout bounds a probe when no other limit is given.
+const DefaultTimeout = 60 * time.Second
+
+// waitDelay is how long Wait keeps reading a killed process's output before
+// giving up on it, in case something it spawned still holds the pipes.
+const waitDelay = 5 * time.Second
+
+// command is exec.CommandContext, except that cancelling ctx kills the
+// whole process group so nothing the binary started outlives it.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// Transcode returns an ffmpeg command with the given arguments that is
+// killed, with its process group, once ctx is done. The caller wires up its
+// input and output and runs it.
+func Transcode(ctx context.Context, args ...string) *exec.Cmd {
+	return command(ctx, "ffmpeg", args...)
+}
+
+// Probe runs ffprobe on path and returns its JSON description of the file's
+// streams and format. ffprobe is killed if it takes longer than timeout, or
+// DefaultTimeout when timeout is 0, so a malformed file can't hang it.
+func Probe(ctx context.Context, path string, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := command(ctx, "ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_streams",
+		"-show_format",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ffprobe aborted: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("ffprobe failed: %v, details: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
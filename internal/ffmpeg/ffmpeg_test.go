@@ -0,0 +1,101 @@
+//go:build unix
+
+package ffmpeg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := Probe(ctx, "testdata/missing.mp4", time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Probe = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Probe took %s with a cancelled context", elapsed)
+	}
+}
+
+func TestCommandAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cmd := command(ctx, "sh", "-c", "sleep 30")
+	if err := cmd.Start(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Start = %v, want context.Canceled", err)
+	}
+	if cmd.Process != nil {
+		t.Error("a process was started for a cancelled context")
+	}
+}
+
+// alive reports whether pid is still running; a zombie waiting for its
+// parent to reap it counts as dead.
+func alive(pid int) bool {
+	dat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name
+	fields := strings.Fields(string(dat[strings.LastIndexByte(string(dat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// TestCommandKillsProcessGroup checks cancelling kills what the command
+// started too, not just the command itself.
+func TestCommandKillsProcessGroup(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("needs /proc")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The shell starts a child in the background, reports its pid and waits
+	cmd := command(ctx, "sh", "-c", "sleep 30 & echo $!; wait")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("bad pid %q", line)
+	}
+	if !alive(child) {
+		t.Fatal("background child isn't running")
+	}
+
+	cancel()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(waitDelay + 5*time.Second):
+		t.Fatal("Wait didn't return after cancel")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for alive(child) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child %d outlived the cancelled command", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package ffmpeg
+
+import "os/exec"
+
+// killProcessGroup leaves cancellation to exec.CommandContext's default of
+// killing cmd's own process, since process groups are a unix feature.
+func killProcessGroup(cmd *exec.Cmd) {}
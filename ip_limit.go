@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// ipLimiter caps how many requests each client address may have in flight
+// at once. It sits in front of auth on upload endpoints, so anonymous
+// floods can't tie up the server reading request bodies.
+type ipLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	trusted []netip.Prefix
+	active  map[netip.Addr]int
+}
+
+// newIPLimiter returns a limiter allowing limit requests per address; 0
+// means unlimited. X-Forwarded-For is only believed from trusted proxies.
+func newIPLimiter(limit int, trusted []netip.Prefix) *ipLimiter {
+	return &ipLimiter{limit: limit, trusted: trusted, active: map[netip.Addr]int{}}
+}
+
+// parseTrustedProxies reads a comma separated list of addresses and CIDR
+// ranges, such as "10.0.0.0/8,127.0.0.1".
+func parseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (l *ipLimiter) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range l.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address a request came from. When the connection
+// is from a trusted proxy, X-Forwarded-For is walked from the right and the
+// first address that isn't another trusted proxy wins; entries further left
+// were written by the client and can't be believed.
+func (l *ipLimiter) clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !l.isTrusted(addr) {
+		return addr, true
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		hop = hop.Unmap()
+		addr = hop
+		if !l.isTrusted(hop) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// acquire takes a slot for addr, reporting false if it's at the limit.
+// Every successful acquire must be paired with a release.
+func (l *ipLimiter) acquire(addr netip.Addr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[addr] >= l.limit {
+		return false
+	}
+	l.active[addr]++
+	return true
+}
+
+func (l *ipLimiter) release(addr netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[addr]--
+	if l.active[addr] <= 0 {
+		delete(l.active, addr)
+	}
+}
+
+// limitPerIP wraps upload handlers so each client address has at most
+// UPLOADS_PER_IP of them running at once, answering 429 beyond that.
+func (cfg *apiConfig) limitPerIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.ipLimit.limit <= 0 {
+			next(w, r)
+			return
+		}
+		addr, ok := cfg.ipLimit.clientAddr(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		if !cfg.ipLimit.acquire(addr) {
+			w.Header().Set("Retry-After", "1")
+			respondWithError(w, http.StatusTooManyRequests, "Too many concurrent uploads from your address", nil)
+			return
+		}
+		defer cfg.ipLimit.release(addr)
+		next(w, r)
+	}
+}
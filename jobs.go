@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+// Finished jobs are forgotten after this long
+const jobRetention = time.Hour
+
+type jobStatus string
+
+const (
+	jobStatusRunning   jobStatus = "running"
+	jobStatusSucceeded jobStatus = "succeeded"
+	jobStatusFailed    jobStatus = "failed"
+)
+
+// jobState is the progress of a job as reported to its owner.
+type jobState struct {
+	ID         uuid.UUID `json:"id"`
+	Kind       string    `json:"kind"`
+	UserID     uuid.UUID `json:"user_id"`
+	VideoID    uuid.UUID `json:"video_id"`
+	Status     jobStatus `json:"status"`
+	Stage      string    `json:"stage"`
+	BytesDone  int64     `json:"bytes_done"`
+	BytesTotal int64     `json:"bytes_total"`
+	// QueuePosition is the job's place in the transcode queue while it waits
+	QueuePosition int       `json:"queue_position,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// job tracks a background operation a user started, such as an import.
+type job struct {
+	mu    sync.Mutex
+	state jobState
+}
+
+func (j *job) snapshot() jobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state
+}
+
+// update applies fn to the job's state under its lock.
+func (j *job) update(fn func(s *jobState)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.state)
+	j.state.UpdatedAt = time.Now().UTC()
+}
+
+func (j *job) setStage(stage string) {
+	j.update(func(s *jobState) { s.Stage = stage })
+}
+
+func (j *job) finish(err error) {
+	j.update(func(s *jobState) {
+		if err != nil {
+			s.Status = jobStatusFailed
+			s.Error = err.Error()
+			return
+		}
+		s.Status = jobStatusSucceeded
+		s.Stage = "done"
+	})
+}
+
+// recoverJob is deferred by background jobs so that a panic fails the job
+// and its video rather than taking the whole server down.
+func (cfg *apiConfig) recoverJob(j *job, videoID uuid.UUID) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	log.Printf("%s job for video %s panicked: %v\n%s", j.snapshot().Kind, videoID, p, debug.Stack())
+	j.finish(errors.New("internal error"))
+	if err := cfg.db.FailVideo(videoID, "internal error"); err != nil {
+		log.Printf("Couldn't mark video %s as failed: %v", videoID, err)
+	}
+}
+
+// errUploadCancelled is the cause given to an upload's context when its
+// owner cancels it.
+var errUploadCancelled = errors.New("upload cancelled by owner")
+
+// jobRegistry keeps recent jobs in memory so their progress can be polled.
+// Jobs don't survive a restart.
+type jobRegistry struct {
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*job
+	// uploads holds the cancel func of each video's in-flight upload or import
+	uploads map[uuid.UUID]*activeUpload
+	// files counts the holds on temp files ingest is working from
+	files map[string]int
+}
+
+type activeUpload struct {
+	cancel context.CancelCauseFunc
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{
+		jobs:    map[uuid.UUID]*job{},
+		uploads: map[uuid.UUID]*activeUpload{},
+		files:   map[string]int{},
+	}
+}
+
+// holdFile marks a temp file as in use until the returned func is called,
+// so the reaper doesn't take it for a leftover.
+func (r *jobRegistry) holdFile(path string) func() {
+	r.mu.Lock()
+	r.files[path]++
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.files[path]--
+		if r.files[path] <= 0 {
+			delete(r.files, path)
+		}
+	}
+}
+
+// fileHeld reports whether path is held, or was made from a held file:
+// intermediate outputs are named by adding a suffix to their source.
+func (r *jobRegistry) fileHeld(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for held := range r.files {
+		if strings.HasPrefix(path, held) {
+			return true
+		}
+	}
+	return false
+}
+
+// trackUpload returns a context for uploading a video's file that
+// cancelUpload can cancel, and a func to call once the upload is over.
+func (r *jobRegistry) trackUpload(parent context.Context, videoID uuid.UUID) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	upload := &activeUpload{cancel: cancel}
+	r.mu.Lock()
+	r.uploads[videoID] = upload
+	r.mu.Unlock()
+	return ctx, func() {
+		r.mu.Lock()
+		// A newer upload of the same video may have replaced this one
+		if r.uploads[videoID] == upload {
+			delete(r.uploads, videoID)
+		}
+		r.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancelUpload stops a video's in-flight upload, reporting whether there
+// was one.
+func (r *jobRegistry) cancelUpload(videoID uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	upload, ok := r.uploads[videoID]
+	if !ok {
+		return false
+	}
+	upload.cancel(errUploadCancelled)
+	delete(r.uploads, videoID)
+	return true
+}
+
+// start registers a new running job, pruning long-finished ones.
+func (r *jobRegistry) start(kind string, userID, videoID uuid.UUID) *job {
+	now := time.Now().UTC()
+	j := &job{state: jobState{
+		ID:        uuid.New(),
+		Kind:      kind,
+		UserID:    userID,
+		VideoID:   videoID,
+		Status:    jobStatusRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, old := range r.jobs {
+		snap := old.snapshot()
+		if snap.Status != jobStatusRunning && now.Sub(snap.UpdatedAt) > jobRetention {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[j.state.ID] = j
+	return j
+}
+
+func (r *jobRegistry) get(id uuid.UUID) (*job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+// latestForVideo returns the most recently started job for a video.
+func (r *jobRegistry) latestForVideo(videoID uuid.UUID) (jobState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest jobState
+	found := false
+	for _, j := range r.jobs {
+		snap := j.snapshot()
+		if snap.VideoID == videoID && (!found || snap.CreatedAt.After(latest.CreatedAt)) {
+			latest = snap
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// running counts the jobs still in progress.
+func (r *jobRegistry) running() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, j := range r.jobs {
+		if j.snapshot().Status == jobStatusRunning {
+			n++
+		}
+	}
+	return n
+}
+
+// Report the progress of a background job the caller started
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	jobIDString := r.PathValue("jobID")
+	jobID, err := uuid.Parse(jobIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	j, ok := cfg.jobs.get(jobID)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+	snap := j.snapshot()
+	if snap.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not the owner of this job", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, snap)
+}
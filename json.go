@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
@@ -12,6 +13,8 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	}
 	if code > 499 {
 		log.Printf("Responding with 5XX error: %s", msg)
+		serverErrors.Add(1)
+		recentServerErrors.add(time.Now())
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
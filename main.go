@@ -4,12 +4,18 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/xaitan80/x-fileserver/internal/auth"
 	"github.com/xaitan80/x-fileserver/internal/database"
+	"github.com/xaitan80/x-fileserver/internal/ffmpeg"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
@@ -25,6 +31,112 @@ type apiConfig struct {
 	s3CfDistribution string
 	port             string
 	s3Client         *s3.Client
+
+	storageTargets []*storageTarget
+	uploadCounter  *atomic.Uint64
+	// assetTargets routes thumbnails, previews and DASH packages to their own buckets
+	assetTargets map[assetClass]*storageTarget
+
+	failedUploadMaxAge time.Duration
+	failedUploadAction string
+
+	adminAPIKey    string
+	usageRecompute *usageRecompute
+	jobs           *jobRegistry
+	imports        importConfig
+
+	// signingSecrets maps integrator key IDs to their HMAC secrets
+	signingSecrets   map[string]string
+	signatureMaxSkew time.Duration
+
+	maintenance           *atomic.Bool
+	maintenanceRetryAfter time.Duration
+
+	// minResolution and maxResolution bound the shorter side of uploaded
+	// videos in pixels; 0 means no bound
+	minResolution int
+	maxResolution int
+	audioPolicy   audioPolicy
+	// uploadTicketRequired refuses uploads without a pre-flight ticket
+	uploadTicketRequired bool
+	uploadTicketTTL      time.Duration
+	// uploadRequireLength rejects video uploads without a Content-Length,
+	// which is what truncated bodies are detected against
+	uploadRequireLength bool
+	// allowedVideoTypes are the media types video uploads may have; all
+	// but video/mp4 are converted to mp4
+	allowedVideoTypes map[string]bool
+	// uploadAsync answers video uploads with 202 and a job once the file is
+	// received, processing it in the background
+	uploadAsync bool
+
+	transcodeTimeoutBase  time.Duration
+	transcodeTimeoutPerGB time.Duration
+	// reencodeMaxGrowth is how much larger than the original a fallback
+	// re-encode may be before the original is kept instead
+	reencodeMaxGrowth  float64
+	ffmpegStallTimeout time.Duration
+	// vfrTargetFPS is the rate variable frame rate sources are re-encoded
+	// at; 0 keeps each source's average
+	vfrTargetFPS int
+
+	transcodeProfiles       map[string]transcodeProfile
+	defaultTranscodeProfile string
+	// streamTranscodes pipes fragmented profiles' output straight to S3
+	streamTranscodes bool
+	// skipProcessing is who may upload files to be stored as sent
+	skipProcessing skipProcessingPolicy
+
+	thumbnailCacheBust bool
+	thumbnailStripICC  bool
+	// thumbnailsToS3 stores thumbnails without a bucket of their own in the
+	// primary video bucket instead of the local assets directory
+	thumbnailsToS3 bool
+	// thumbnailFormat, when set, is the format every thumbnail is stored in
+	thumbnailFormat  *thumbnailFormat
+	thumbnailQuality int
+	// thumbnailMaxEdge is the longest edge uploaded thumbnails are shrunk
+	// to fit; 0 keeps them as uploaded
+	thumbnailMaxEdge int
+
+	defaultVideoSort     database.VideoSort
+	strictFieldSelection bool
+
+	idempotencyKeyTTL time.Duration
+
+	preview previewConfig
+
+	dashEnabled bool
+	// dashRenditions is the bitrate ladder DASH packages are encoded at;
+	// empty copies the processed streams as a single rendition
+	dashRenditions []database.Rendition
+	// chaptersVTT also publishes chapters as a WebVTT track
+	chaptersVTT bool
+
+	objectKeyTemplate objectKeyTemplate
+	transcodeQueue    *transcodeQueue
+
+	// streams limits concurrent proxied downloads per user
+	streams *streamLimiter
+
+	// ipLimit limits concurrent uploads per client address, before auth
+	ipLimit *ipLimiter
+
+	audit *auditLogger
+
+	processingLogs processingLogs
+
+	// quota notifies when users' storage nears their tier's quota
+	quota quotaConfig
+
+	// callbackSecret signs requests to uploads' callback_url
+	callbackSecret string
+
+	// features are the optional endpoints that are switched on
+	features featureFlags
+
+	// openapiSpec is the JSON served at /openapi.json
+	openapiSpec []byte
 }
 
 func main() {
@@ -39,12 +151,34 @@ func main() {
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	// Uploads and jobs don't survive a restart, so videos they left busy
+	// would otherwise refuse every new upload with 409
+	if n, err := db.FailInterruptedVideos("interrupted"); err != nil {
+		log.Printf("Couldn't fail interrupted videos: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted videos as failed", n)
+	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
 
+	// Tolerate this much clock skew when checking token exp/nbf
+	auth.SetClockSkewLeeway(getEnvDuration("JWT_LEEWAY", 30*time.Second))
+
+	// Rotating JWT_SECRET: give it a new JWT_KEY_ID and move the old secret
+	// into JWT_PREVIOUS_SECRETS until tokens it signed have expired
+	previousJWTSecrets, err := parseSigningSecrets(os.Getenv("JWT_PREVIOUS_SECRETS"))
+	if err != nil {
+		log.Fatalf("Invalid JWT_PREVIOUS_SECRETS: %v", err)
+	}
+	jwtKeyID := os.Getenv("JWT_KEY_ID")
+	if _, ok := previousJWTSecrets[jwtKeyID]; ok && jwtKeyID != "" {
+		log.Fatalf("JWT_KEY_ID %q is also in JWT_PREVIOUS_SECRETS", jwtKeyID)
+	}
+	auth.SetSigningKeys(jwtKeyID, previousJWTSecrets)
+
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM environment variable is not set")
@@ -80,6 +214,163 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
+	// Failed uploads are only reaped when a max age is configured
+	failedUploadMaxAge := getEnvDuration("FAILED_UPLOAD_MAX_AGE", 0)
+	failedUploadAction := os.Getenv("FAILED_UPLOAD_ACTION")
+	if failedUploadAction == "" {
+		failedUploadAction = failedUploadActionDelete
+	}
+	if failedUploadAction != failedUploadActionDelete && failedUploadAction != failedUploadActionDraft {
+		log.Fatal("FAILED_UPLOAD_ACTION must be either \"delete\" or \"draft\"")
+	}
+
+	// ffmpeg gets killed once a transcode runs past this, optionally scaled by input size
+	transcodeTimeoutBase := getEnvDuration("TRANSCODE_TIMEOUT", 30*time.Minute)
+	transcodeTimeoutPerGB := getEnvDuration("TRANSCODE_TIMEOUT_PER_GB", 0)
+	reencodeMaxGrowth := getEnvInt("REENCODE_MAX_GROWTH_PERCENT", 10)
+	if reencodeMaxGrowth < 0 {
+		log.Fatal("REENCODE_MAX_GROWTH_PERCENT can't be negative")
+	}
+
+	// ...and sooner if it reports no progress for this long (0 disables the watchdog)
+	ffmpegStallTimeout := getEnvDuration("FFMPEG_STALL_TIMEOUT", 2*time.Minute)
+
+	// ffprobe only reads headers, so one that runs this long is stuck
+	ffprobeTimeout = getEnvDuration("FFPROBE_TIMEOUT", ffmpeg.DefaultTimeout)
+
+	vfrTargetFPS := getEnvInt("VFR_TARGET_FPS", 0)
+	if vfrTargetFPS < 0 {
+		log.Fatal("VFR_TARGET_FPS can't be negative")
+	}
+
+	// Named encoder settings uploads can choose with a "profile" form field
+	transcodeProfiles, err := parseTranscodeProfiles(os.Getenv("TRANSCODE_PROFILES"))
+	if err != nil {
+		log.Fatalf("Invalid TRANSCODE_PROFILES: %v", err)
+	}
+	defaultProfile := os.Getenv("TRANSCODE_DEFAULT_PROFILE")
+	if defaultProfile == "" {
+		defaultProfile = defaultTranscodeProfile
+	}
+	if _, ok := transcodeProfiles[defaultProfile]; !ok {
+		log.Fatalf("TRANSCODE_DEFAULT_PROFILE %q isn't a configured profile", defaultProfile)
+	}
+
+	// Reject uploads outside these resolutions ("480p" = 480), before transcoding
+	minResolution := getEnvInt("VIDEO_MIN_RESOLUTION", 0)
+	maxResolution := getEnvInt("VIDEO_MAX_RESOLUTION", 0)
+	if minResolution < 0 || maxResolution < 0 || (maxResolution > 0 && minResolution > maxResolution) {
+		log.Fatalf("VIDEO_MIN_RESOLUTION (%d) and VIDEO_MAX_RESOLUTION (%d) must be non-negative, with min <= max", minResolution, maxResolution)
+	}
+
+	// Whether uploads must have sound, must be silent, or either
+	audioPolicy, err := parseAudioPolicy(os.Getenv("AUDIO_POLICY"))
+	if err != nil {
+		log.Fatalf("Invalid AUDIO_POLICY: %v", err)
+	}
+
+	// Opt-in: version thumbnail URLs by updated_at so replaced images aren't served stale
+	thumbnailCacheBust := getEnvBool("THUMBNAIL_CACHE_BUST", false)
+
+	// Opt-in: drop embedded colour profiles so thumbnails render as sRGB everywhere
+	thumbnailStripICC := getEnvBool("THUMBNAIL_STRIP_ICC", false)
+
+	// Opt-in: re-encode every thumbnail to one format so clients see one extension
+	thumbnailFormat, err := parseThumbnailFormat(os.Getenv("THUMBNAIL_FORMAT"))
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_FORMAT: %v", err)
+	}
+	thumbnailQuality := getEnvInt("THUMBNAIL_QUALITY", 85)
+	if thumbnailQuality < 1 || thumbnailQuality > 100 {
+		log.Fatalf("THUMBNAIL_QUALITY must be between 1 and 100, got %d", thumbnailQuality)
+	}
+	thumbnailMaxEdge := getEnvInt("THUMBNAIL_MAX_EDGE", 1280)
+	if thumbnailMaxEdge < 0 {
+		log.Fatal("THUMBNAIL_MAX_EDGE can't be negative")
+	}
+
+	// Order of the video library when a request doesn't pass ?sort=
+	defaultVideoSort := database.VideoSort(os.Getenv("VIDEO_DEFAULT_SORT"))
+	if defaultVideoSort == "" {
+		defaultVideoSort = database.VideoSortNewest
+	}
+	if !database.ValidVideoSort(defaultVideoSort) {
+		log.Fatal("VIDEO_DEFAULT_SORT must be one of \"newest\", \"oldest\", \"updated\" or \"title\"")
+	}
+
+	// How long a retried request with the same Idempotency-Key is answered from the stored result
+	idempotencyKeyTTL := getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+
+	// Animated hover previews: generated at upload, lazily on first request, or not at all
+	preview := previewConfig{
+		mode:     os.Getenv("PREVIEW_MODE"),
+		format:   os.Getenv("PREVIEW_FORMAT"),
+		duration: getEnvDuration("PREVIEW_DURATION", 3*time.Second),
+		fps:      getEnvInt("PREVIEW_FPS", 10),
+		width:    getEnvInt("PREVIEW_WIDTH", 320),
+	}
+	if preview.mode == "" {
+		preview.mode = previewModeOff
+	}
+	if preview.mode != previewModeOff && preview.mode != previewModeUpload && preview.mode != previewModeLazy {
+		log.Fatal("PREVIEW_MODE must be one of \"off\", \"upload\" or \"lazy\"")
+	}
+	if preview.format == "" {
+		preview.format = "webp"
+	}
+	if preview.format != "webp" && preview.format != "gif" {
+		log.Fatal("PREVIEW_FORMAT must be either \"webp\" or \"gif\"")
+	}
+
+	// Opt-in: also package uploads as MPEG-DASH for adaptive players
+	dashEnabled := getEnvBool("DASH_ENABLED", false)
+	dashRenditions, err := parseDASHRenditions(os.Getenv("DASH_RENDITIONS"))
+	if err != nil {
+		log.Fatalf("Invalid DASH_RENDITIONS: %v", err)
+	}
+
+	// Transcode worker slots, handed out by user tier weight
+	queueTiers, err := parseQueueTiers(os.Getenv("TRANSCODE_TIERS"))
+	if err != nil {
+		log.Fatalf("Invalid TRANSCODE_TIERS: %v", err)
+	}
+	defaultTier := os.Getenv("TRANSCODE_DEFAULT_TIER")
+	if defaultTier == "" {
+		defaultTier = "free"
+	}
+	if _, ok := queueTiers[defaultTier]; !ok {
+		queueTiers[defaultTier] = 0
+	}
+	transcodeWorkers := getEnvInt("TRANSCODE_WORKERS", 2)
+	if transcodeWorkers < 1 {
+		log.Fatal("TRANSCODE_WORKERS must be at least 1")
+	}
+
+	// Layout of uploaded video keys in the bucket
+	keyTemplate, err := parseObjectKeyTemplate(os.Getenv("OBJECT_KEY_TEMPLATE"))
+	if err != nil {
+		log.Fatalf("Invalid OBJECT_KEY_TEMPLATE: %v", err)
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Admin endpoints stay disabled unless a key is configured
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+
+	// Machine callers can sign admin requests with a per-integrator secret instead
+	signingSecrets, err := parseSigningSecrets(os.Getenv("SIGNING_SECRETS"))
+	if err != nil {
+		log.Fatalf("Invalid SIGNING_SECRETS: %v", err)
+	}
+
+	// Maintenance mode pauses uploads and processing; admins can also toggle it at runtime
+	maintenance := &atomic.Bool{}
+	maintenance.Store(getEnvBool("MAINTENANCE_MODE", false))
+	maintenanceRetryAfter := getEnvDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute)
+
 	// Load AWS config and create S3 client
 	awsCfg, err := config.LoadDefaultConfig(
 		context.Background(),
@@ -90,6 +381,98 @@ func main() {
 	}
 	s3Client := s3.NewFromConfig(awsCfg)
 
+	// Objects can be encrypted at rest with S3- or KMS-managed keys
+	sse, err := parseServerSideEncryption(os.Getenv("S3_SSE"), os.Getenv("S3_SSE_KMS_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Invalid S3_SSE: %v", err)
+	}
+
+	// Uploads go to the primary bucket unless S3_BUCKETS adds regional
+	// ones, each with its own CloudFront distribution
+	storageTargets := []*storageTarget{{
+		region:       s3Region,
+		bucket:       s3Bucket,
+		distribution: s3CfDistribution,
+		client:       s3Client,
+	}}
+	if spec := os.Getenv("S3_BUCKETS"); spec != "" {
+		regionalTargets, err := parseStorageTargets(spec)
+		if err != nil {
+			log.Fatalf("Invalid S3_BUCKETS: %v", err)
+		}
+		for _, target := range regionalTargets {
+			connectStorageTarget(awsCfg, target)
+		}
+		storageTargets = append(storageTargets, regionalTargets...)
+	}
+
+	// Thumbnails, previews and DASH packages can each live in their own bucket
+	assetTargets, err := parseAssetTargets(os.Getenv("ASSET_BUCKETS"))
+	if err != nil {
+		log.Fatalf("Invalid ASSET_BUCKETS: %v", err)
+	}
+	allTargets := append([]*storageTarget{}, storageTargets...)
+	for class, target := range assetTargets {
+		connectStorageTarget(awsCfg, target)
+		allTargets = append(allTargets, target)
+		log.Printf("Storing %s assets in bucket %s (%s)", class, target.bucket, target.distribution)
+	}
+
+	// Every configured bucket, video or asset, must be reachable before we
+	// serve, so a typo fails here rather than on the first upload
+	for _, target := range allTargets {
+		checkStorageTarget(target)
+		target.sse = sse
+	}
+	log.Printf("Encrypting stored objects with %s", sse)
+
+	// Per-video processing logs for support; off unless a directory is set
+	processingLogDir := os.Getenv("PROCESSING_LOG_DIR")
+	if processingLogDir != "" {
+		if err := os.MkdirAll(processingLogDir, 0o750); err != nil {
+			log.Fatalf("Couldn't create PROCESSING_LOG_DIR: %v", err)
+		}
+	}
+
+	// Storage quotas per tier, and webhooks as usage crosses thresholds of them
+	quotaLimits, err := parseQuotaLimits(os.Getenv("QUOTA_TIERS"))
+	if err != nil {
+		log.Fatalf("Invalid QUOTA_TIERS: %v", err)
+	}
+	quotaThresholdSpec := os.Getenv("QUOTA_ALERT_THRESHOLDS")
+	if quotaThresholdSpec == "" {
+		quotaThresholdSpec = "80,100"
+	}
+	quotaThresholds, err := parseQuotaThresholds(quotaThresholdSpec)
+	if err != nil {
+		log.Fatalf("Invalid QUOTA_ALERT_THRESHOLDS: %v", err)
+	}
+	quotaWebhookURL := os.Getenv("QUOTA_WEBHOOK_URL")
+	if quotaWebhookURL != "" {
+		if u, err := url.Parse(quotaWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("QUOTA_WEBHOOK_URL must be an http or https URL")
+		}
+	}
+
+	videoTypeSpec := os.Getenv("ALLOWED_VIDEO_TYPES")
+	if videoTypeSpec == "" {
+		videoTypeSpec = defaultVideoTypes
+	}
+	allowedVideoTypes, err := parseVideoTypes(videoTypeSpec)
+	if err != nil {
+		log.Fatalf("Invalid ALLOWED_VIDEO_TYPES: %v", err)
+	}
+
+	features, err := parseFeatureFlags(os.Getenv("FEATURE_FLAGS"))
+	if err != nil {
+		log.Fatalf("Invalid FEATURE_FLAGS: %v", err)
+	}
+
+	openapiSpec, err := buildOpenAPISpec()
+	if err != nil {
+		log.Fatalf("Couldn't build OpenAPI spec: %v", err)
+	}
+
 	cfg := apiConfig{
 		db:               db,
 		jwtSecret:        jwtSecret,
@@ -101,6 +484,89 @@ func main() {
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
 		s3Client:         s3Client,
+
+		storageTargets: storageTargets,
+		uploadCounter:  &atomic.Uint64{},
+		assetTargets:   assetTargets,
+
+		failedUploadMaxAge: failedUploadMaxAge,
+		failedUploadAction: failedUploadAction,
+
+		adminAPIKey:    adminAPIKey,
+		usageRecompute: &usageRecompute{},
+		jobs:           newJobRegistry(),
+		imports: importConfig{
+			maxBytes:     int64(getEnvInt("IMPORT_MAX_BYTES", 1<<30)),
+			allowedHosts: parseHostList(os.Getenv("IMPORT_ALLOWED_HOSTS")),
+			deniedHosts:  parseHostList(os.Getenv("IMPORT_DENIED_HOSTS")),
+		},
+
+		signingSecrets:   signingSecrets,
+		signatureMaxSkew: getEnvDuration("SIGNATURE_MAX_SKEW", 5*time.Minute),
+
+		maintenance:           maintenance,
+		maintenanceRetryAfter: maintenanceRetryAfter,
+
+		minResolution: minResolution,
+		maxResolution: maxResolution,
+		audioPolicy:   audioPolicy,
+
+		uploadTicketRequired: getEnvBool("UPLOAD_TICKET_REQUIRED", false),
+		uploadTicketTTL:      getEnvDuration("UPLOAD_TICKET_TTL", 15*time.Minute),
+		uploadRequireLength:  getEnvBool("UPLOAD_REQUIRE_CONTENT_LENGTH", false),
+		allowedVideoTypes:    allowedVideoTypes,
+		uploadAsync:          getEnvBool("UPLOAD_ASYNC", false),
+
+		transcodeTimeoutBase:  transcodeTimeoutBase,
+		transcodeTimeoutPerGB: transcodeTimeoutPerGB,
+		reencodeMaxGrowth:     float64(reencodeMaxGrowth) / 100,
+		vfrTargetFPS:          vfrTargetFPS,
+		ffmpegStallTimeout:    ffmpegStallTimeout,
+
+		transcodeProfiles:       transcodeProfiles,
+		defaultTranscodeProfile: defaultProfile,
+		streamTranscodes:        getEnvBool("TRANSCODE_STREAM_UPLOAD", false),
+		skipProcessing:          parseSkipProcessingTiers(os.Getenv("SKIP_PROCESSING_TIERS")),
+
+		thumbnailCacheBust: thumbnailCacheBust,
+		thumbnailStripICC:  thumbnailStripICC,
+		thumbnailsToS3:     getEnvBool("THUMBNAILS_TO_S3", false),
+		thumbnailFormat:    thumbnailFormat,
+		thumbnailQuality:   thumbnailQuality,
+		thumbnailMaxEdge:   thumbnailMaxEdge,
+		defaultVideoSort:   defaultVideoSort,
+
+		strictFieldSelection: getEnvBool("RESPONSE_FIELDS_STRICT", false),
+
+		idempotencyKeyTTL: idempotencyKeyTTL,
+
+		preview: preview,
+
+		dashEnabled:    dashEnabled,
+		dashRenditions: dashRenditions,
+		chaptersVTT:    getEnvBool("CHAPTERS_VTT", false),
+
+		objectKeyTemplate: keyTemplate,
+		transcodeQueue:    newTranscodeQueue(transcodeWorkers, queueTiers, defaultTier),
+
+		streams: newStreamLimiter(getEnvInt("STREAMS_PER_USER", 0)),
+		ipLimit: newIPLimiter(getEnvInt("UPLOADS_PER_IP", 0), trustedProxies),
+
+		audit: newAuditLogger(db),
+		processingLogs: processingLogs{
+			dir:       processingLogDir,
+			retention: getEnvDuration("PROCESSING_LOG_RETENTION", 7*24*time.Hour),
+		},
+		quota: quotaConfig{
+			limits:        quotaLimits,
+			thresholds:    quotaThresholds,
+			webhookURL:    quotaWebhookURL,
+			webhookSecret: os.Getenv("QUOTA_WEBHOOK_SECRET"),
+		},
+		callbackSecret: os.Getenv("CALLBACK_SECRET"),
+
+		features:    features,
+		openapiSpec: openapiSpec,
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -108,27 +574,96 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	if cfg.failedUploadMaxAge > 0 {
+		go cfg.runFailedUploadReaper(context.Background())
+	}
+	if cfg.processingLogs.dir != "" {
+		go cfg.runProcessingLogPruner(context.Background())
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	assetsFS := http.Dir(assetsRoot)
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(assetsFS))
+	mux.Handle("/assets/", assetCacheMiddleware(assetsFS, getEnvDuration("ASSETS_CACHE_MAX_AGE", 365*24*time.Hour), assetsHandler))
+
+	mux.HandleFunc("GET /readyz", cfg.handlerReadyz)
+	mux.HandleFunc("GET /api/capabilities", cfg.handlerCapabilities)
+	mux.HandleFunc("GET /openapi.json", cfg.handlerOpenAPI)
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
+	mux.HandleFunc("GET /api/settings", cfg.handlerSettings)
+	mux.HandleFunc("PUT /api/settings", cfg.handlerSettings)
+
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
 
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideosCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/videos", cfg.idempotent(cfg.handlerVideosCreate))
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail_from_frame", cfg.withFeature(featureFrameThumbs, cfg.duringMaintenance(cfg.handlerThumbnailFromFrame)))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.limitPerIP(cfg.duringMaintenance(cfg.idempotent(cfg.handlerUploadThumbnail))))
+	mux.HandleFunc("POST /api/videos/{videoID}/import", cfg.withFeature(featureImport, cfg.duringMaintenance(cfg.handlerVideoImport)))
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_ticket", cfg.duringMaintenance(cfg.handlerUploadTicket))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.limitPerIP(cfg.duringMaintenance(cfg.idempotent(cfg.handlerUploadVideo))))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/upload", cfg.handlerUploadCancel)
+	mux.HandleFunc("POST /api/videos/{videoID}/multipart/start", cfg.withFeature(featureMultipart, cfg.duringMaintenance(cfg.handlerMultipartStart)))
+	mux.HandleFunc("GET /api/videos/{videoID}/multipart/part", cfg.withFeature(featureMultipart, cfg.handlerMultipartPart))
+	mux.HandleFunc("GET /api/videos/{videoID}/multipart", cfg.withFeature(featureMultipart, cfg.handlerMultipartParts))
+	mux.HandleFunc("POST /api/videos/{videoID}/multipart/complete", cfg.withFeature(featureMultipart, cfg.duringMaintenance(cfg.handlerMultipartComplete)))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/multipart", cfg.withFeature(featureMultipart, cfg.handlerMultipartAbort))
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("POST /api/videos/urls", cfg.handlerVideoURLsBatch)
+	mux.HandleFunc("GET /api/videos/status", cfg.handlerVideosStatus)
+	mux.HandleFunc("GET /api/videos/changes", cfg.handlerVideoChanges)
+	mux.HandleFunc("GET /api/videos/grouped_by_aspect", cfg.handlerVideosGroupedByAspect)
+	mux.HandleFunc("GET /api/videos/recent", cfg.handlerVideosRecent)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/url/valid", cfg.handlerVideoURLValid)
+	mux.HandleFunc("GET /api/videos/{videoID}/preview", cfg.handlerVideoPreview)
+	mux.HandleFunc("GET /api/videos/{videoID}/download", cfg.withFeature(featureProxyStreaming, cfg.handlerVideoDownload))
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail", cfg.handlerThumbnailGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail_url", cfg.handlerThumbnailURLGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/assets", cfg.handlerVideoAssets)
+	mux.HandleFunc("GET /api/videos/{videoID}/techinfo", cfg.handlerVideoTechInfo)
+	mux.HandleFunc("POST /api/videos/{videoID}/audio_tracks", cfg.withFeature(featureAudioTracks, cfg.limitPerIP(cfg.duringMaintenance(cfg.handlerAudioTrackUpload))))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/audio_tracks/{language}", cfg.withFeature(featureAudioTracks, cfg.handlerAudioTrackDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/archive.zip", cfg.withFeature(featureArchive, cfg.handlerVideoArchive))
+	mux.HandleFunc("POST /api/videos/{videoID}/clone", cfg.withFeature(featureClone, cfg.duringMaintenance(cfg.handlerVideoClone)))
+	mux.HandleFunc("PUT /api/videos/{videoID}/chapters", cfg.handlerVideoChaptersUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/history", cfg.handlerVideoHistory)
+	mux.HandleFunc("GET /api/videos/{videoID}/metadata", cfg.handlerVideoMetadataGet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}/metadata", cfg.handlerVideoMetadataUpdate)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoUpdate)
+	mux.HandleFunc("POST /api/videos/{videoID}/share", cfg.withFeature(featureSharing, cfg.handlerVideoShare))
+	mux.HandleFunc("GET /api/videos/{videoID}/shares", cfg.withFeature(featureSharing, cfg.handlerVideoSharesList))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share/{userID}", cfg.withFeature(featureSharing, cfg.handlerVideoUnshare))
+	mux.HandleFunc("GET /api/videos/shared_with_me", cfg.withFeature(featureSharing, cfg.handlerVideosSharedWithMe))
+	mux.HandleFunc("POST /api/videos/{videoID}/share_link", cfg.withFeature(featureShareLinks, cfg.handlerShareLinkCreate))
+	mux.HandleFunc("GET /api/videos/{videoID}/share_links", cfg.withFeature(featureShareLinks, cfg.handlerShareLinksList))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/share_links/{token}", cfg.withFeature(featureShareLinks, cfg.handlerShareLinkRevoke))
+	mux.HandleFunc("GET /share/{token}", cfg.withFeature(featureShareLinks, cfg.handlerShareLinkOpen))
+	mux.HandleFunc("GET /api/users/{userID}/feed.xml", cfg.withFeature(featureFeeds, cfg.handlerUserFeed))
+	mux.HandleFunc("GET /api/usage/detailed", cfg.handlerUsageDetailed)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("POST /api/admin/recompute_usage", cfg.handlerAdminRecomputeUsage)
+	mux.HandleFunc("GET /api/admin/recompute_usage", cfg.handlerAdminRecomputeUsageStatus)
+	mux.HandleFunc("GET /api/admin/audit", cfg.handlerAdminAudit)
+	mux.HandleFunc("GET /api/admin/videos/by_key", cfg.handlerAdminVideoByKey)
+	mux.HandleFunc("GET /api/admin/videos/{videoID}/logs", cfg.handlerAdminVideoLogs)
+	mux.HandleFunc("GET /api/admin/orphans", cfg.handlerAdminOrphans)
+	mux.HandleFunc("POST /api/admin/orphans/purge", cfg.handlerAdminOrphansPurge)
+	mux.HandleFunc("GET /api/admin/metrics", cfg.handlerAdminMetrics)
+	mux.HandleFunc("GET /api/admin/status", cfg.handlerAdminStatus)
+	mux.HandleFunc("GET /api/admin/s3/ping", cfg.handlerAdminS3Ping)
+	mux.HandleFunc("PUT /api/admin/users/{userID}/tier", cfg.handlerAdminUserTier)
+	mux.HandleFunc("GET /api/admin/maintenance", cfg.handlerAdminMaintenance)
+	mux.HandleFunc("PUT /api/admin/maintenance", cfg.handlerAdminMaintenance)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
@@ -138,3 +673,19 @@ func main() {
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
+
+// connectStorageTarget gives a configured bucket a client for its region.
+func connectStorageTarget(awsCfg aws.Config, target *storageTarget) {
+	target.client = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.Region = target.region
+	})
+}
+
+// checkStorageTarget exits unless the target's bucket can be reached.
+func checkStorageTarget(target *storageTarget) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := target.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &target.bucket}); err != nil {
+		log.Fatalf("Couldn't access bucket %s in %s: %v", target.bucket, target.region, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// duringMaintenance wraps handlers that start uploads or processing so they
+// answer 503 while maintenance mode is on. Requests and jobs that were
+// already running finish normally, and read endpoints keep serving.
+func (cfg *apiConfig) duringMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.maintenance.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(int(cfg.maintenanceRetryAfter.Seconds())))
+			respondWithError(w, http.StatusServiceUnavailable, "Uploads and processing are paused for maintenance", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Turn maintenance mode on or off
+func (cfg *apiConfig) handlerAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Enabled *bool `json:"enabled"`
+	}
+	type response struct {
+		Enabled bool `json:"enabled"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var params parameters
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Enabled == nil {
+			respondWithError(w, http.StatusBadRequest, "Body must be {\"enabled\": true|false}", err)
+			return
+		}
+		if cfg.maintenance.Swap(*params.Enabled) != *params.Enabled {
+			log.Printf("Maintenance mode set to %t", *params.Enabled)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Enabled: cfg.maintenance.Load()})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultObjectKeyTemplate lays objects out the way uploads always have:
+// an orientation folder followed by a random name.
+const defaultObjectKeyTemplate = "{orientation}/{random}{ext}"
+
+var keyPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// knownKeyPlaceholders lists every placeholder a key template may use. Only
+// the ones marked true are fresh for each upload, and a template needs at
+// least one of them so a re-upload never lands on an existing object.
+var knownKeyPlaceholders = map[string]bool{
+	"{orientation}": false,
+	"{userID}":      false,
+	"{videoID}":     false,
+	"{year}":        false,
+	"{month}":       false,
+	"{day}":         false,
+	"{ext}":         false,
+	"{random}":      true,
+	"{uuid}":        true,
+}
+
+// objectKeyTemplate builds S3 keys for uploaded videos.
+type objectKeyTemplate string
+
+// parseObjectKeyTemplate checks that a template only uses known
+// placeholders and includes a uniqueness token.
+func parseObjectKeyTemplate(raw string) (objectKeyTemplate, error) {
+	if strings.TrimSpace(raw) == "" {
+		return defaultObjectKeyTemplate, nil
+	}
+	if strings.HasPrefix(raw, "/") {
+		return "", errors.New("key template must not start with /")
+	}
+	unique := false
+	for _, ph := range keyPlaceholder.FindAllString(raw, -1) {
+		fresh, ok := knownKeyPlaceholders[ph]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder %s in key template", ph)
+		}
+		unique = unique || fresh
+	}
+	if leftover := keyPlaceholder.ReplaceAllString(raw, ""); strings.ContainsAny(leftover, "{}") {
+		return "", errors.New("unbalanced braces in key template")
+	}
+	if !unique {
+		return "", errors.New("key template must include {random} or {uuid}")
+	}
+	return objectKeyTemplate(raw), nil
+}
+
+// objectKeyParams holds the values substituted into a key template.
+type objectKeyParams struct {
+	orientation string
+	userID      uuid.UUID
+	videoID     uuid.UUID
+	ext         string
+	now         time.Time
+}
+
+// build evaluates the template for a single upload.
+func (t objectKeyTemplate) build(p objectKeyParams) (string, error) {
+	randomName, err := randomObjectName()
+	if err != nil {
+		return "", err
+	}
+	now := p.now.UTC()
+	return strings.NewReplacer(
+		"{orientation}", p.orientation,
+		"{userID}", p.userID.String(),
+		"{videoID}", p.videoID.String(),
+		"{year}", fmt.Sprintf("%04d", now.Year()),
+		"{month}", fmt.Sprintf("%02d", int(now.Month())),
+		"{day}", fmt.Sprintf("%02d", now.Day()),
+		"{ext}", p.ext,
+		"{random}", randomName,
+		"{uuid}", uuid.NewString(),
+	).Replace(string(t)), nil
+}
+
+// orientationFolder maps an aspect ratio to the folder name used in keys.
+func orientationFolder(aspect string) string {
+	switch aspect {
+	case "16:9":
+		return "landscape"
+	case "9:16":
+		return "portrait"
+	default:
+		return "other"
+	}
+}
+
+// keyOrientation recovers the orientation folder from a key made by the
+// default template, for copies that shouldn't re-probe the video. Keys
+// from other templates get "other".
+func keyOrientation(key string) string {
+	folder, _, _ := strings.Cut(key, "/")
+	switch folder {
+	case "landscape", "portrait":
+		return folder
+	}
+	return "other"
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// openapiBase is the hand-maintained part of the spec: paths, parameters and
+// request bodies. The schemas of response types are generated from the Go
+// types at startup so they can't drift from what the handlers send.
+//
+//go:embed openapi.json
+var openapiBase []byte
+
+// openapiSchemas are the types published under components/schemas. Fields of
+// these types reference each other by name instead of being inlined.
+var openapiSchemas = map[string]reflect.Type{
+	"Video":           reflect.TypeOf(database.Video{}),
+	"Rendition":       reflect.TypeOf(database.Rendition{}),
+	"Chapter":         reflect.TypeOf(database.Chapter{}),
+	"User":            reflect.TypeOf(database.User{}),
+	"AudioTrack":      reflect.TypeOf(database.AudioTrack{}),
+	"ShareLink":       reflect.TypeOf(database.ShareLink{}),
+	"UserSettings":    reflect.TypeOf(database.UserSettings{}),
+	"MultipartUpload": reflect.TypeOf(database.MultipartUpload{}),
+	"Job":             reflect.TypeOf(jobState{}),
+	"TechInfo":        reflect.TypeOf(videoTechInfo{}),
+	"VideoShare":      reflect.TypeOf(database.VideoShare{}),
+	"AuditEvent":      reflect.TypeOf(database.AuditEvent{}),
+	"UsageSummary":    reflect.TypeOf(database.UsageSummary{}),
+	"UsageRecompute":  reflect.TypeOf(usageRecompute{}),
+	"OrphanObject":    reflect.TypeOf(orphanObject{}),
+	"S3PingResult":    reflect.TypeOf(s3PingResult{}),
+}
+
+// openapiEnums lists the values of string types that are enumerations.
+var openapiEnums = map[reflect.Type][]string{
+	reflect.TypeOf(database.VideoStatus("")): {
+		string(database.VideoStatusDraft),
+		string(database.VideoStatusUploading),
+		string(database.VideoStatusProcessing),
+		string(database.VideoStatusReady),
+		string(database.VideoStatusFailed),
+	},
+	reflect.TypeOf(database.SharePermission("")): {
+		string(database.SharePermissionRead),
+		string(database.SharePermissionEdit),
+	},
+	reflect.TypeOf(jobStatus("")): {
+		string(jobStatusRunning),
+		string(jobStatusSucceeded),
+		string(jobStatusFailed),
+	},
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	uuidType       = reflect.TypeOf(uuid.UUID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// buildOpenAPISpec fills the embedded spec's schemas in and returns it as JSON.
+func buildOpenAPISpec() ([]byte, error) {
+	var spec map[string]any
+	if err := json.Unmarshal(openapiBase, &spec); err != nil {
+		return nil, fmt.Errorf("couldn't parse openapi.json: %w", err)
+	}
+	components, ok := spec["components"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("openapi.json has no components")
+	}
+	schemas, ok := components["schemas"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("openapi.json has no components.schemas")
+	}
+
+	refs := make(map[reflect.Type]string, len(openapiSchemas))
+	for name, t := range openapiSchemas {
+		refs[t] = name
+	}
+	for name, t := range openapiSchemas {
+		if _, ok := schemas[name]; ok {
+			return nil, fmt.Errorf("schema %s is both generated and in openapi.json", name)
+		}
+		schemas[name] = structSchema(t, refs)
+	}
+	return json.Marshal(spec)
+}
+
+// typeSchema describes t the way encoding/json serializes it, referring to
+// the named schemas in refs rather than repeating them.
+func typeSchema(t reflect.Type, refs map[reflect.Type]string) map[string]any {
+	if name, ok := refs[t]; ok {
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]any{"type": "string", "format": "uuid"}
+	case rawMessageType:
+		// Any JSON value
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem := typeSchema(t.Elem(), refs)
+		if _, ok := elem["$ref"]; ok {
+			// OpenAPI 3.0 ignores siblings of $ref, so wrap it
+			return map[string]any{"allOf": []any{elem}, "nullable": true}
+		}
+		elem["nullable"] = true
+		return elem
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), refs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), refs)}
+	case reflect.Struct:
+		return structSchema(t, refs)
+	case reflect.String:
+		s := map[string]any{"type": "string"}
+		if values, ok := openapiEnums[t]; ok {
+			s["enum"] = values
+		}
+		return s
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// structSchema describes a struct's JSON object. Embedded structs without a
+// tag are flattened into it, as encoding/json does, and every key without
+// omitempty is required because it's always sent.
+func structSchema(t reflect.Type, refs map[reflect.Type]string) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				walk(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = typeSchema(field.Type, refs)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	s := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// Serve the OpenAPI description of the API. It needs no auth so tooling can
+// fetch it before logging in.
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	sum := sha256.Sum256(cfg.openapiSpec)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cfg.openapiSpec)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func loadOpenAPISpec(t *testing.T) map[string]any {
+	t.Helper()
+	dat, err := buildOpenAPISpec()
+	if err != nil {
+		t.Fatalf("buildOpenAPISpec: %v", err)
+	}
+	var spec map[string]any
+	if err := json.Unmarshal(dat, &spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// TestOpenAPIDocumentsEveryRoute keeps the hand-written paths in step with
+// the routes main registers.
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	paths := spec["paths"].(map[string]any)
+
+	src, err := os.ReadFile("main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := regexp.MustCompile(`mux\.HandleFunc\("([A-Z]+) ([^"]+)"`).FindAllStringSubmatch(string(src), -1)
+	if len(routes) == 0 {
+		t.Fatal("found no routes in main.go")
+	}
+	for _, route := range routes {
+		method, path := strings.ToLower(route[1]), route[2]
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			t.Errorf("%s %s isn't in openapi.json", route[1], path)
+			continue
+		}
+		if _, ok := item[method]; !ok {
+			t.Errorf("%s %s isn't in openapi.json", route[1], path)
+		}
+	}
+}
+
+// TestOpenAPIRefsResolve checks every $ref points at something defined.
+func TestOpenAPIRefsResolve(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				var target any = spec
+				for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					m, ok := target.(map[string]any)
+					if !ok {
+						target = nil
+						break
+					}
+					target = m[part]
+				}
+				if target == nil {
+					t.Errorf("%s doesn't resolve", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(spec)
+}
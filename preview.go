@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	previewModeOff    = "off"
+	previewModeUpload = "upload"
+	previewModeLazy   = "lazy"
+)
+
+// previewConfig controls the short looping clips used for hover previews.
+type previewConfig struct {
+	mode     string
+	format   string // "webp" or "gif"
+	duration time.Duration
+	fps      int
+	width    int
+}
+
+func (p previewConfig) contentType() string {
+	if p.format == "gif" {
+		return "image/gif"
+	}
+	return "image/webp"
+}
+
+// generatePreview cuts a clip from the middle of a video and encodes it as a
+// small looping animation, returning the path of the new file. A fast seek
+// is usually fine here since the clip only needs to be roughly central.
+func generatePreview(ctx context.Context, srcPath string, opts previewConfig, seek seekMode, stall time.Duration) (string, error) {
+	duration, err := getVideoDuration(ctx, srcPath)
+	if err != nil {
+		return "", err
+	}
+	clip := opts.duration.Seconds()
+	start := duration/2 - clip/2
+	if start < 0 {
+		start = 0
+	}
+
+	outputPath := srcPath + ".preview." + opts.format
+	filter := fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", opts.fps, opts.width)
+	args := seek.inputArgs(srcPath, start)
+	args = append(args,
+		"-t", strconv.FormatFloat(clip, 'f', 3, 64),
+		"-vf", filter,
+		"-an",
+		"-loop", "0",
+	)
+	if opts.format == "webp" {
+		args = append(args, "-c:v", "libwebp", "-quality", "60")
+	}
+	args = append(args, "-y", outputPath)
+
+	if err := runFFmpeg(ctx, stall, args...); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg preview failed: %w", err)
+	}
+	return outputPath, nil
+}
+
+// createPreview generates a preview from a local copy of the video, stores it
+// in S3 and records its URL, returning that URL.
+func (cfg *apiConfig) createPreview(ctx context.Context, target *storageTarget, videoID uuid.UUID, srcPath string, seek seekMode) (string, error) {
+	previewPath, err := generatePreview(ctx, srcPath, cfg.preview, seek, cfg.ffmpegStallTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(previewPath)
+
+	previewFile, err := os.Open(previewPath)
+	if err != nil {
+		return "", err
+	}
+	defer previewFile.Close()
+
+	name, err := randomObjectName()
+	if err != nil {
+		return "", err
+	}
+	key := "previews/" + name + "." + cfg.preview.format
+	if err := target.putObject(ctx, key, previewFile, cfg.preview.contentType()); err != nil {
+		return "", err
+	}
+
+	previewURL := target.objectURL(key)
+	if err := cfg.db.SetPreviewURL(videoID, &previewURL); err != nil {
+		return "", err
+	}
+	return previewURL, nil
+}
+
+// Return a video's animated preview, generating it on first request when
+// previews are created lazily. ?seek=accurate makes that generation cut the
+// clip exactly rather than from the nearest keyframe.
+func (cfg *apiConfig) handlerVideoPreview(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		PreviewURL string `json:"preview_url"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	seek, err := parseSeekMode(r.URL.Query().Get("seek"), seekModeFast)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	allowed, err := cfg.canAccessVideo(video, userID, database.SharePermissionRead)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check access", err)
+		return
+	}
+	if !allowed {
+		respondWithError(w, http.StatusUnauthorized, "No access to this video", nil)
+		return
+	}
+
+	if video.PreviewURL != nil {
+		respondWithJSON(w, http.StatusOK, response{PreviewURL: *video.PreviewURL})
+		return
+	}
+	if cfg.preview.mode != previewModeLazy {
+		respondWithError(w, http.StatusNotFound, "Video has no preview", nil)
+		return
+	}
+	if video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no uploaded file yet", nil)
+		return
+	}
+	target, key, ok := cfg.locateObject(*video.VideoURL)
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Video file isn't stored in our bucket", nil)
+		return
+	}
+
+	srcPath, err := target.downloadObject(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't download video", err)
+		return
+	}
+	defer os.Remove(srcPath)
+
+	previewURL, err := cfg.createPreview(r.Context(), cfg.assetTarget(assetPreview, target), videoID, srcPath, seek)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate preview", err)
+		return
+	}
+
+	log.Printf("Generated preview for video %s on first request", videoID)
+	respondWithJSON(w, http.StatusOK, response{PreviewURL: previewURL})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xaitan80/x-fileserver/internal/ffmpeg"
+)
+
+// maxProbeCacheEntries bounds how many files' probe results are remembered
+const maxProbeCacheEntries = 256
+
+// ffprobeTimeout is how long ffprobe may take on one file. It's set from
+// FFPROBE_TIMEOUT at startup.
+var ffprobeTimeout = ffmpeg.DefaultTimeout
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		RFrameRate   string `json:"r_frame_rate"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Size       string `json:"size"`
+	} `json:"format"`
+}
+
+// fileStamp identifies a version of a file on disk. A file whose size or
+// modification time changes is probed again.
+type fileStamp struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// probeCache remembers ffprobe output by file path, size and modification
+// time, so probing the same file again doesn't run ffprobe twice. ffprobe
+// only reads a file's headers, so the key mustn't cost a full read either.
+type probeCache struct {
+	mu      sync.Mutex
+	results map[fileStamp]ffprobeOutput
+	order   []fileStamp // oldest first
+}
+
+var probes = &probeCache{
+	results: map[fileStamp]ffprobeOutput{},
+}
+
+// probeVideo returns ffprobe's view of a local file's streams and format.
+func probeVideo(ctx context.Context, filePath string) (ffprobeOutput, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return ffprobeOutput{}, err
+	}
+	stamp := fileStamp{path: filePath, size: info.Size(), modTime: info.ModTime()}
+	if probe, ok := probes.get(stamp); ok {
+		return probe, nil
+	}
+
+	probe, err := runProbe(ctx, filePath)
+	if err != nil {
+		return ffprobeOutput{}, err
+	}
+	probes.put(stamp, probe)
+	return probe, nil
+}
+
+// runProbe runs ffprobe on a local path or URL, bypassing the cache. Given
+// a URL, ffprobe reads just the headers it needs with range requests.
+func runProbe(ctx context.Context, input string) (ffprobeOutput, error) {
+	out, err := ffmpeg.Probe(ctx, input, ffprobeTimeout)
+	if err != nil {
+		return ffprobeOutput{}, err
+	}
+
+	var probe ffprobeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return ffprobeOutput{}, fmt.Errorf("unmarshal failed: %w", err)
+	}
+	return probe, nil
+}
+
+func (c *probeCache) get(stamp fileStamp) (ffprobeOutput, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	probe, ok := c.results[stamp]
+	return probe, ok
+}
+
+func (c *probeCache) put(stamp fileStamp, probe ffprobeOutput) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.results[stamp]; ok {
+		return
+	}
+	// Forget older versions of the same path
+	for i := 0; i < len(c.order); i++ {
+		if c.order[i].path == stamp.path {
+			delete(c.results, c.order[i])
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			i--
+		}
+	}
+	if len(c.order) >= maxProbeCacheEntries {
+		delete(c.results, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.results[stamp] = probe
+	c.order = append(c.order, stamp)
+}
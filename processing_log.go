@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const (
+	processingLogPruneInterval = time.Hour
+	// processingLogPollInterval is how often a followed log is checked for
+	// new output
+	processingLogPollInterval = 500 * time.Millisecond
+)
+
+// processingLogs keeps a log file per video of every processing run: the
+// steps taken and what ffmpeg printed, so failed uploads can be diagnosed
+// without shell access. An empty dir turns logging off.
+type processingLogs struct {
+	dir       string
+	retention time.Duration
+}
+
+func (p processingLogs) path(videoID uuid.UUID) string {
+	return filepath.Join(p.dir, videoID.String()+".log")
+}
+
+// processingLog is one run's open log file. ffmpeg runs can overlap within
+// a run (DASH and previews), so writes are serialized.
+type processingLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (l *processingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+func (l *processingLog) printf(format string, args ...interface{}) {
+	fmt.Fprintf(l, "%s %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+type processingLogKey struct{}
+
+// processingLogFrom returns the log carried by ctx, or nil if none is.
+func processingLogFrom(ctx context.Context) *processingLog {
+	l, _ := ctx.Value(processingLogKey{}).(*processingLog)
+	return l
+}
+
+// logProcessing notes a processing step in the log carried by ctx, if any.
+func logProcessing(ctx context.Context, format string, args ...interface{}) {
+	if l := processingLogFrom(ctx); l != nil {
+		l.printf(format, args...)
+	}
+}
+
+// openProcessingLog starts a run in the video's log, appending to earlier
+// runs, and returns a ctx that carries it to ffmpeg. The returned func
+// records the run's outcome and closes the log.
+func (cfg *apiConfig) openProcessingLog(ctx context.Context, videoID uuid.UUID) (context.Context, func(err error)) {
+	if cfg.processingLogs.dir == "" {
+		return ctx, func(error) {}
+	}
+	f, err := os.OpenFile(cfg.processingLogs.path(videoID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		log.Printf("Couldn't open processing log for video %s: %v", videoID, err)
+		return ctx, func(error) {}
+	}
+	l := &processingLog{f: f}
+	l.printf("=== processing started")
+	return context.WithValue(ctx, processingLogKey{}, l), func(err error) {
+		if err != nil {
+			l.printf("=== processing failed: %v", err)
+		} else {
+			l.printf("=== processing finished")
+		}
+		f.Close()
+	}
+}
+
+// runProcessingLogPruner periodically deletes logs older than the retention.
+func (cfg *apiConfig) runProcessingLogPruner(ctx context.Context) {
+	ticker := time.NewTicker(processingLogPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg.pruneProcessingLogs()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) pruneProcessingLogs() {
+	cutoff := time.Now().Add(-cfg.processingLogs.retention)
+	entries, err := os.ReadDir(cfg.processingLogs.dir)
+	if err != nil {
+		log.Printf("Couldn't list processing logs: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cfg.processingLogs.dir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Couldn't delete processing log %s: %v", entry.Name(), err)
+		}
+	}
+}
+
+// Stream a video's processing log, for support. While the video is still
+// processing the response stays open and new output is sent as it's
+// written, until processing ends or the client hangs up.
+func (cfg *apiConfig) handlerAdminVideoLogs(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	if cfg.processingLogs.dir == "" {
+		respondWithError(w, http.StatusNotFound, "Processing logs are disabled", nil)
+		return
+	}
+
+	f, err := os.Open(cfg.processingLogs.path(videoID))
+	if errors.Is(err, fs.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "No processing logs for this video", nil)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open processing log", err)
+		return
+	}
+	defer f.Close()
+	// A log past its retention is as good as gone, even if not pruned yet
+	if info, err := f.Stat(); err == nil && time.Since(info.ModTime()) > cfg.processingLogs.retention {
+		respondWithError(w, http.StatusNotFound, "No processing logs for this video", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	ticker := time.NewTicker(processingLogPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := io.Copy(w, f); err != nil {
+			return
+		}
+		rc.Flush()
+
+		video, err := cfg.db.GetVideo(videoID)
+		if err != nil || video.Status != database.VideoStatusProcessing {
+			// Pick up anything written as processing finished
+			io.Copy(w, f)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
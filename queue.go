@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// transcodeQueue limits how many transcodes run at once. Waiting transcodes
+// get a free slot in order of their user's tier weight, oldest first within
+// a tier.
+type transcodeQueue struct {
+	mu          sync.Mutex
+	workers     int
+	free        int
+	weights     map[string]int
+	defaultTier string
+	waiting     []*queueTicket
+	seq         uint64
+}
+
+type queueTicket struct {
+	weight int
+	seq    uint64
+	ready  chan struct{}
+	// position, if set, is told the ticket's 1-based place in line
+	position func(int)
+}
+
+func newTranscodeQueue(workers int, weights map[string]int, defaultTier string) *transcodeQueue {
+	return &transcodeQueue{
+		workers:     workers,
+		free:        workers,
+		weights:     weights,
+		defaultTier: defaultTier,
+	}
+}
+
+// parseQueueTiers reads "paid:10,free:1" into tier weights.
+func parseQueueTiers(raw string) (map[string]int, error) {
+	tiers := map[string]int{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, weight, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid tier %q, want name:weight", entry)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for tier %q: %w", name, err)
+		}
+		tiers[name] = w
+	}
+	return tiers, nil
+}
+
+// weightOf returns a tier's weight, treating unknown tiers as the default.
+func (q *transcodeQueue) weightOf(tier string) int {
+	if w, ok := q.weights[tier]; ok {
+		return w
+	}
+	return q.weights[q.defaultTier]
+}
+
+// acquire waits for a transcode slot. The returned func gives the slot back.
+func (q *transcodeQueue) acquire(ctx context.Context, tier string, position func(int)) (func(), error) {
+	q.mu.Lock()
+	if q.free > 0 && len(q.waiting) == 0 {
+		q.free--
+		q.mu.Unlock()
+		return q.release, nil
+	}
+
+	q.seq++
+	t := &queueTicket{
+		weight:   q.weightOf(tier),
+		seq:      q.seq,
+		ready:    make(chan struct{}),
+		position: position,
+	}
+	i := sort.Search(len(q.waiting), func(i int) bool {
+		w := q.waiting[i]
+		return w.weight < t.weight || (w.weight == t.weight && w.seq > t.seq)
+	})
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = t
+	q.notifyPositions(i)
+	q.mu.Unlock()
+
+	select {
+	case <-t.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for i, w := range q.waiting {
+			if w == t {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				q.notifyPositions(i)
+				return nil, ctx.Err()
+			}
+		}
+		// The slot was handed over just as we gave up; pass it on
+		q.releaseLocked()
+		return nil, ctx.Err()
+	}
+}
+
+// stats reports the queue's slots and how many transcodes are waiting.
+func (q *transcodeQueue) stats() (workers, free, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.workers, q.free, len(q.waiting)
+}
+
+func (q *transcodeQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked()
+}
+
+func (q *transcodeQueue) releaseLocked() {
+	if len(q.waiting) == 0 {
+		q.free++
+		return
+	}
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(next.ready)
+	if next.position != nil {
+		next.position(0)
+	}
+	q.notifyPositions(0)
+}
+
+// notifyPositions tells waiting tickets from index i on where they stand.
+func (q *transcodeQueue) notifyPositions(from int) {
+	for i := from; i < len(q.waiting); i++ {
+		if p := q.waiting[i].position; p != nil {
+			p(i + 1)
+		}
+	}
+}
+
+// Set a user's queue tier, which decides how soon their transcodes run
+func (cfg *apiConfig) handlerAdminUserTier(w http.ResponseWriter, r *http.Request) {
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	var params struct {
+		Tier string `json:"tier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if _, ok := cfg.transcodeQueue.weights[params.Tier]; !ok {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown tier %q", params.Tier), nil)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	if err := cfg.db.SetUserTier(userID, params.Tier); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		UserID uuid.UUID `json:"user_id"`
+		Tier   string    `json:"tier"`
+	}{userID, params.Tier})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xaitan80/x-fileserver/internal/auth"
+)
+
+const (
+	// quotaRearmMargin is how many percentage points usage must fall below
+	// a threshold before crossing it again sends another notification, so
+	// usage hovering around a threshold doesn't notify on every upload
+	quotaRearmMargin = 5
+
+	quotaWebhookAttempts = 3
+	quotaWebhookTimeout  = 10 * time.Second
+)
+
+// quotaConfig is each tier's storage quota and who to tell when users near it.
+type quotaConfig struct {
+	// limits is the quota in bytes by tier; tiers without one are unlimited
+	limits map[string]int64
+	// thresholds are the percentages of quota that notify, ascending
+	thresholds    []int
+	webhookURL    string
+	webhookSecret string
+}
+
+// parseQuotaLimits reads per-tier quotas as name:bytes, comma separated.
+func parseQuotaLimits(raw string) (map[string]int64, error) {
+	limits := map[string]int64{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, size, ok := strings.Cut(entry, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid quota %q, want tier:bytes", entry)
+		}
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid quota for tier %q: must be a positive number of bytes", name)
+		}
+		limits[name] = n
+	}
+	return limits, nil
+}
+
+// parseQuotaThresholds reads percentages such as "80,100".
+func parseQuotaThresholds(raw string) ([]int, error) {
+	var thresholds []int
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(entry, "%"))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid threshold %q: must be a positive percentage", entry)
+		}
+		if !slices.Contains(thresholds, n) {
+			thresholds = append(thresholds, n)
+		}
+	}
+	slices.Sort(thresholds)
+	return thresholds, nil
+}
+
+// highestReached is the largest threshold usage has reached, less margin
+// percentage points, or 0 if none.
+func (q quotaConfig) highestReached(percent float64, margin int) int {
+	reached := 0
+	for _, t := range q.thresholds {
+		if percent >= float64(t-margin) {
+			reached = t
+		}
+	}
+	return reached
+}
+
+// quotaEvent is the body of a quota webhook.
+type quotaEvent struct {
+	Event        string    `json:"event"`
+	UserID       uuid.UUID `json:"user_id"`
+	Email        string    `json:"email"`
+	Tier         string    `json:"tier"`
+	Threshold    int       `json:"threshold_percent"`
+	StorageBytes int64     `json:"storage_bytes"`
+	QuotaBytes   int64     `json:"quota_bytes"`
+	At           time.Time `json:"at"`
+}
+
+// checkQuota compares a user's storage against their tier's quota after it
+// changes, notifying once each time a higher threshold is crossed. Falling
+// back below a threshold (by more than quotaRearmMargin) re-arms it.
+func (cfg *apiConfig) checkQuota(userID uuid.UUID) {
+	if len(cfg.quota.limits) == 0 || len(cfg.quota.thresholds) == 0 {
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		if err != nil {
+			log.Printf("Couldn't check quota of user %s: %v", userID, err)
+		}
+		return
+	}
+	tier := user.Tier
+	if tier == "" {
+		tier = cfg.transcodeQueue.defaultTier
+	}
+	limit, ok := cfg.quota.limits[tier]
+	if !ok {
+		return
+	}
+	notified, err := cfg.db.GetQuotaNotified(userID)
+	if err != nil {
+		log.Printf("Couldn't check quota of user %s: %v", userID, err)
+		return
+	}
+
+	percent := float64(user.StorageBytes) * 100 / float64(limit)
+	reached := cfg.quota.highestReached(percent, 0)
+	switch {
+	case reached > notified:
+		swapped, err := cfg.db.SwapQuotaNotified(userID, notified, reached)
+		if err != nil {
+			log.Printf("Couldn't record quota notification for user %s: %v", userID, err)
+			return
+		}
+		if !swapped {
+			// Another update already handled this crossing
+			return
+		}
+		log.Printf("User %s reached %d%% of their %s quota (%d of %d bytes)", userID, reached, tier, user.StorageBytes, limit)
+		go cfg.sendQuotaWebhook(quotaEvent{
+			Event:        "quota.threshold_crossed",
+			UserID:       userID,
+			Email:        user.Email,
+			Tier:         tier,
+			Threshold:    reached,
+			StorageBytes: user.StorageBytes,
+			QuotaBytes:   limit,
+			At:           time.Now().UTC(),
+		})
+	case reached < notified:
+		if held := cfg.quota.highestReached(percent, quotaRearmMargin); held < notified {
+			if _, err := cfg.db.SwapQuotaNotified(userID, notified, held); err != nil {
+				log.Printf("Couldn't re-arm quota notification for user %s: %v", userID, err)
+			}
+		}
+	}
+}
+
+// sendQuotaWebhook POSTs the event to the configured URL, retrying a few
+// times. With a secret, requests are signed like the ones integrators send
+// us: X-Signature-Timestamp plus an HMAC-SHA256 of auth.StringToSign in
+// X-Webhook-Signature.
+func (cfg *apiConfig) sendQuotaWebhook(event quotaEvent) {
+	if cfg.quota.webhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Couldn't encode quota webhook: %v", err)
+		return
+	}
+	target, err := url.Parse(cfg.quota.webhookURL)
+	if err != nil {
+		log.Printf("Invalid quota webhook URL: %v", err)
+		return
+	}
+
+	client := &http.Client{Timeout: quotaWebhookTimeout}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(client, target, body, cfg.quota.webhookSecret)
+		if err == nil {
+			return
+		}
+		if attempt == quotaWebhookAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 4
+	}
+	log.Printf("Couldn't deliver quota webhook for user %s after %d attempts: %v", event.UserID, quotaWebhookAttempts, err)
+}
+
+// postWebhook makes one signed delivery attempt of a JSON webhook body.
+func postWebhook(client *http.Client, target *url.URL, body []byte, secret string) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(auth.SignatureTimestampHeader, timestamp)
+		req.Header.Set("X-Webhook-Signature", auth.SignRequest(secret, auth.StringToSign(http.MethodPost, target.RequestURI(), timestamp, body)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
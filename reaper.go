@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+const reaperInterval = 15 * time.Minute
+
+const (
+	failedUploadActionDelete = "delete"
+	failedUploadActionDraft  = "draft"
+)
+
+// runFailedUploadReaper periodically cleans up videos stuck in the failed
+// state, along with whatever the failed attempt left behind.
+func (cfg *apiConfig) runFailedUploadReaper(ctx context.Context) {
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+
+	for {
+		cfg.reapFailedUploads(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) reapFailedUploads(ctx context.Context) {
+	cutoff := time.Now().UTC().Add(-cfg.failedUploadMaxAge)
+
+	videos, err := cfg.db.GetFailedVideos(cutoff)
+	if err != nil {
+		log.Printf("Reaper: couldn't list failed videos: %v", err)
+		return
+	}
+
+	for _, video := range videos {
+		// Processing leaves the file and what was made from it; a deleted
+		// video takes its thumbnail and audio tracks with it too, while a
+		// draft keeps them
+		urls := []*string{video.VideoURL, video.PreviewURL, video.DashURL, video.ChaptersURL}
+		if cfg.failedUploadAction != failedUploadActionDraft {
+			urls = append(urls, video.ThumbnailURL)
+			tracks, err := cfg.db.GetAudioTracks(video.ID)
+			if err != nil {
+				log.Printf("Reaper: couldn't list audio tracks of video %s: %v", video.ID, err)
+				continue
+			}
+			for _, track := range tracks {
+				urls = append(urls, &track.URL)
+			}
+		}
+		if err := cfg.deleteVideoObjects(ctx, video, urls); err != nil {
+			log.Printf("Reaper: couldn't delete objects of video %s: %v", video.ID, err)
+			continue
+		}
+
+		reclaimed := video.SizeBytes
+		switch cfg.failedUploadAction {
+		case failedUploadActionDraft:
+			err = cfg.db.SetVideoURL(video.ID, nil, 0)
+			if err == nil {
+				err = cfg.db.SetPreviewURL(video.ID, nil)
+			}
+			if err == nil {
+				err = cfg.db.SetDashURL(video.ID, nil, nil)
+			}
+			if err == nil {
+				err = cfg.db.SetChapters(video.ID, nil, nil)
+			}
+			if err == nil {
+				err = cfg.db.SetStatus(video.ID, database.VideoStatusDraft)
+			}
+		default:
+			err = cfg.db.DeleteVideo(video.ID)
+		}
+		if err != nil {
+			log.Printf("Reaper: couldn't %s failed video %s: %v", cfg.failedUploadAction, video.ID, err)
+			continue
+		}
+		if err := cfg.db.AddUserStorageBytes(video.UserID, -reclaimed); err != nil {
+			log.Printf("Reaper: couldn't update storage usage for user %s: %v", video.UserID, err)
+		} else {
+			cfg.checkQuota(video.UserID)
+		}
+		if cfg.failedUploadAction == failedUploadActionDelete {
+			cfg.audit.record(nil, video.ID, auditActionDelete)
+		} else {
+			cfg.audit.record(nil, video.ID, auditActionUpdate)
+		}
+		log.Printf("Reaper: reclaimed failed video %s (%s, %d bytes)", video.ID, cfg.failedUploadAction, reclaimed)
+	}
+
+	cfg.removeStaleUploadFiles(cutoff)
+}
+
+// deleteVideoObjects deletes the stored objects behind a video's urls,
+// skipping nil ones and any outside our buckets. A DASH manifest takes its
+// segments with it. Objects another video also refers to, such as a
+// thumbnail shared with a clone, are kept.
+func (cfg *apiConfig) deleteVideoObjects(ctx context.Context, video database.Video, urls []*string) error {
+	stored, err := cfg.db.GetStoredURLs()
+	if err != nil {
+		return err
+	}
+	refs := map[string]int{}
+	for _, u := range stored {
+		refs[u]++
+	}
+
+	for _, u := range urls {
+		if u == nil || refs[*u] > 1 {
+			continue
+		}
+		target, key, ok := cfg.locateObject(*u)
+		if !ok {
+			continue
+		}
+		if path.Base(key) == dashManifestName {
+			keys, err := target.listObjects(ctx, path.Dir(key)+"/")
+			if err != nil {
+				return err
+			}
+			failed, err := target.deleteObjects(ctx, keys)
+			if err != nil {
+				return err
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("couldn't delete %d DASH objects under %s", len(failed), path.Dir(key))
+			}
+			log.Printf("Reaper: deleted %d DASH objects for video %s", len(keys), video.ID)
+			continue
+		}
+		if err := target.deleteObject(ctx, key); err != nil {
+			return err
+		}
+		log.Printf("Reaper: deleted object %s for video %s", key, video.ID)
+	}
+	return nil
+}
+
+// removeStaleUploadFiles deletes temp files left by uploads that died before
+// their deferred cleanup could run. Files an upload or import is still
+// working from, queued or transcoding, are left alone however old.
+func (cfg *apiConfig) removeStaleUploadFiles(cutoff time.Time) {
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), "tubely-upload-*"))
+	if err != nil {
+		log.Printf("Reaper: couldn't list temp files: %v", err)
+		return
+	}
+	for _, path := range matches {
+		if cfg.jobs.fileHeld(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Reaper: couldn't remove temp file %s: %v", path, err)
+			continue
+		}
+		log.Printf("Reaper: removed temp file %s (%d bytes)", path, info.Size())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// skipProcessingPolicy decides whose uploads may be stored exactly as sent,
+// skipping the remux and re-encode. The zero value allows nobody.
+type skipProcessingPolicy struct {
+	all   bool
+	tiers map[string]bool
+}
+
+// parseSkipProcessingTiers reads a comma-separated list of tiers allowed to
+// skip processing; "*" allows every user.
+func parseSkipProcessingTiers(spec string) skipProcessingPolicy {
+	policy := skipProcessingPolicy{tiers: map[string]bool{}}
+	for _, tier := range strings.Split(spec, ",") {
+		tier = strings.TrimSpace(tier)
+		switch tier {
+		case "":
+		case "*":
+			policy.all = true
+		default:
+			policy.tiers[tier] = true
+		}
+	}
+	return policy
+}
+
+func (p skipProcessingPolicy) enabled() bool {
+	return p.all || len(p.tiers) > 0
+}
+
+// canSkipProcessing reports whether the user's tier may upload with
+// skip_processing. Users without a tier count as the queue's default tier.
+func (cfg *apiConfig) canSkipProcessing(userID uuid.UUID) (bool, error) {
+	if cfg.skipProcessing.all {
+		return true, nil
+	}
+	if !cfg.skipProcessing.enabled() {
+		return false, nil
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return false, err
+	}
+	tier := cfg.transcodeQueue.defaultTier
+	if user != nil && user.Tier != "" {
+		tier = user.Tier
+	}
+	return cfg.skipProcessing.tiers[tier], nil
+}
+
+// checkMP4 makes sure a file ffprobe reads as an MP4 with a video stream,
+// since a skipped upload is served without ffmpeg ever having parsed it.
+func checkMP4(ctx context.Context, filePath string) error {
+	return checkVideoContent(ctx, filePath, "video/mp4")
+}
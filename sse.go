@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// serverSideEncryption is how S3 should encrypt the objects we write. The
+// zero value leaves it to the bucket's default.
+type serverSideEncryption struct {
+	mode types.ServerSideEncryption
+	// kmsKeyID is the KMS key for aws:kms; empty uses the account's
+	// AWS-managed key
+	kmsKeyID *string
+}
+
+// parseServerSideEncryption checks an SSE mode and KMS key ID from config.
+func parseServerSideEncryption(mode, kmsKeyID string) (serverSideEncryption, error) {
+	var sse serverSideEncryption
+	switch types.ServerSideEncryption(mode) {
+	case "":
+		if kmsKeyID != "" {
+			return sse, errors.New("a KMS key ID needs the aws:kms mode")
+		}
+		return sse, nil
+	case types.ServerSideEncryptionAes256:
+		if kmsKeyID != "" {
+			return sse, errors.New("a KMS key ID can only be used with aws:kms, not AES256")
+		}
+	case types.ServerSideEncryptionAwsKms:
+		if kmsKeyID != "" {
+			sse.kmsKeyID = &kmsKeyID
+		}
+	default:
+		return sse, fmt.Errorf("unknown mode %q; expected AES256 or aws:kms", mode)
+	}
+	sse.mode = types.ServerSideEncryption(mode)
+	return sse, nil
+}
+
+func (e serverSideEncryption) String() string {
+	if e.mode == "" {
+		return "bucket default"
+	}
+	if e.kmsKeyID != nil {
+		return fmt.Sprintf("%s with key %s", e.mode, *e.kmsKeyID)
+	}
+	return string(e.mode)
+}
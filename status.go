@@ -0,0 +1,95 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	uploadsActive = expvar.NewInt("uploads_active")
+	serverErrors  = expvar.NewInt("server_errors")
+)
+
+// errorWindowBuckets is how many minutes of server errors the admin status
+// reports
+const errorWindowBuckets = 5
+
+// errorWindow counts events per minute over the last few minutes.
+type errorWindow struct {
+	mu      sync.Mutex
+	minutes [errorWindowBuckets]int64
+	counts  [errorWindowBuckets]int64
+}
+
+var recentServerErrors = &errorWindow{}
+
+func (e *errorWindow) add(now time.Time) {
+	minute := now.Unix() / 60
+	i := minute % errorWindowBuckets
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.minutes[i] != minute {
+		e.minutes[i] = minute
+		e.counts[i] = 0
+	}
+	e.counts[i]++
+}
+
+// total is the number of events in the window ending at now.
+func (e *errorWindow) total(now time.Time) int64 {
+	minute := now.Unix() / 60
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var total int64
+	for i := range e.counts {
+		if minute-e.minutes[i] < errorWindowBuckets {
+			total += e.counts[i]
+		}
+	}
+	return total
+}
+
+// Report load for a lightweight admin UI: uploads and imports in flight,
+// the transcode queue, recent server errors and free temp space.
+func (cfg *apiConfig) handlerAdminStatus(w http.ResponseWriter, r *http.Request) {
+	type queueStatus struct {
+		Workers int `json:"workers"`
+		Free    int `json:"free"`
+		Waiting int `json:"waiting"`
+	}
+	type response struct {
+		ActiveUploads     int64       `json:"active_uploads"`
+		ActiveJobs        int         `json:"active_jobs"`
+		TranscodeQueue    queueStatus `json:"transcode_queue"`
+		ServerErrors5m    int64       `json:"server_errors_5m"`
+		ServerErrorsTotal int64       `json:"server_errors_total"`
+		FFmpegStalls      int64       `json:"ffmpeg_stalls"`
+		TempDir           string      `json:"temp_dir"`
+		TempDirFreeBytes  *uint64     `json:"temp_dir_free_bytes"`
+	}
+
+	if err := cfg.authorizeAdmin(r); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't authorize admin", err)
+		return
+	}
+
+	workers, free, waiting := cfg.transcodeQueue.stats()
+	resp := response{
+		ActiveUploads:     uploadsActive.Value(),
+		ActiveJobs:        cfg.jobs.running(),
+		TranscodeQueue:    queueStatus{Workers: workers, Free: free, Waiting: waiting},
+		ServerErrors5m:    recentServerErrors.total(time.Now()),
+		ServerErrorsTotal: serverErrors.Value(),
+		FFmpegStalls:      ffmpegStalls.Value(),
+		TempDir:           os.TempDir(),
+	}
+	// Unsupported platforms report null rather than failing the whole status
+	if freeBytes, err := diskFree(resp.TempDir); err == nil {
+		resp.TempDirFreeBytes = &freeBytes
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// storageTarget is a bucket together with the CloudFront distribution that
+// serves it. Stored URLs name the distribution, which is how we find the
+// bucket an object lives in later.
+type storageTarget struct {
+	region       string
+	bucket       string
+	distribution string
+	client       *s3.Client
+	// sse encrypts every object written to the bucket, copies included
+	sse serverSideEncryption
+}
+
+// objectURL is the CloudFront URL clients use to fetch an object.
+func (t *storageTarget) objectURL(key string) string {
+	return fmt.Sprintf("https://%s/%s", t.distribution, key)
+}
+
+func (t *storageTarget) putObject(ctx context.Context, key string, body io.Reader, contentType string) error {
+	return t.put(ctx, key, body, contentType, nil)
+}
+
+// putVideo stores a video file with the Content-Disposition it's to be
+// served with, so it never needs rewriting with a copy afterwards.
+func (t *storageTarget) putVideo(ctx context.Context, key string, body io.Reader, contentType, disposition string) error {
+	return t.put(ctx, key, body, contentType, &disposition)
+}
+
+func (t *storageTarget) put(ctx context.Context, key string, body io.Reader, contentType string, disposition *string) error {
+	_, err := t.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:               &t.bucket,
+		Key:                  &key,
+		Body:                 body,
+		ContentType:          &contentType,
+		ContentDisposition:   disposition,
+		ServerSideEncryption: t.sse.mode,
+		SSEKMSKeyId:          t.sse.kmsKeyID,
+	})
+	return err
+}
+
+// streamPartSize is the multipart part size for uploads of unknown length.
+const streamPartSize = 8 << 20 // 8MB
+
+// uploadStream stores a video whose length isn't known up front as key and
+// returns how many bytes it stored. Only one part is held in memory at a
+// time; bodies smaller than a part are stored with a single PUT.
+func (t *storageTarget) uploadStream(ctx context.Context, key string, body io.Reader, contentType, disposition string) (int64, error) {
+	buf := make([]byte, streamPartSize)
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return int64(n), t.putVideo(ctx, key, bytes.NewReader(buf[:n]), contentType, disposition)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	created, err := t.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               &t.bucket,
+		Key:                  &key,
+		ContentType:          &contentType,
+		ContentDisposition:   &disposition,
+		ServerSideEncryption: t.sse.mode,
+		SSEKMSKeyId:          t.sse.kmsKeyID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	abort := func(err error) (int64, error) {
+		t.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   &t.bucket,
+			Key:      &key,
+			UploadId: created.UploadId,
+		})
+		return 0, err
+	}
+
+	var parts []types.CompletedPart
+	var size int64
+	for partNumber := int32(1); ; partNumber++ {
+		part, err := t.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     &t.bucket,
+			Key:        &key,
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(partNumber),
+			Body:       bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.ETag, PartNumber: aws.Int32(partNumber)})
+		size += int64(n)
+
+		n, err = io.ReadFull(body, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+	}
+
+	_, err = t.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &t.bucket,
+		Key:             &key,
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return abort(err)
+	}
+	return size, nil
+}
+
+// startMultipart begins a multipart upload for clients to send parts of
+// key to directly, returning its upload ID.
+func (t *storageTarget) startMultipart(ctx context.Context, key, contentType string) (string, error) {
+	// Parts are encrypted with the settings given here, so the presigned
+	// part URLs don't need to carry them
+	out, err := t.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               &t.bucket,
+		Key:                  &key,
+		ContentType:          &contentType,
+		ServerSideEncryption: t.sse.mode,
+		SSEKMSKeyId:          t.sse.kmsKeyID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.UploadId == nil {
+		return "", fmt.Errorf("no upload ID returned for %s", key)
+	}
+	return *out.UploadId, nil
+}
+
+// presignPart returns a URL the client can PUT one part of a multipart
+// upload to, without credentials, until ttl has passed.
+func (t *storageTarget) presignPart(ctx context.Context, key, uploadID string, partNumber int32, ttl time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(t.client).PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     &t.bucket,
+		Key:        &key,
+		UploadId:   &uploadID,
+		PartNumber: aws.Int32(partNumber),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// presignGet returns a URL that reads an object, without credentials, until
+// ttl has passed. It honours Range requests, so a reader such as ffprobe
+// fetches only the bytes it needs.
+func (t *storageTarget) presignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(t.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &t.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// listParts returns the parts of a multipart upload S3 has received so far.
+func (t *storageTarget) listParts(ctx context.Context, key, uploadID string) ([]types.Part, error) {
+	out, err := t.client.ListParts(ctx, &s3.ListPartsInput{
+		Bucket:   &t.bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Parts, nil
+}
+
+// completeMultipart assembles the listed parts into the object.
+func (t *storageTarget) completeMultipart(ctx context.Context, key, uploadID string, parts []types.CompletedPart) error {
+	_, err := t.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &t.bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// abortMultipart discards a multipart upload and every part sent to it.
+func (t *storageTarget) abortMultipart(ctx context.Context, key, uploadID string) error {
+	_, err := t.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &t.bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	return err
+}
+
+// objectSize returns the size of an object in bytes.
+func (t *storageTarget) objectSize(ctx context.Context, key string) (int64, error) {
+	head, err := t.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &t.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(head.ContentLength), nil
+}
+
+// downloadObject copies an object into a new temp file, returning its path.
+// The caller is responsible for removing it.
+func (t *storageTarget) downloadObject(ctx context.Context, key string) (string, error) {
+	out, err := t.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &t.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+
+	tempFile, err := os.CreateTemp("", "tubely-download-*")
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	if _, err := io.Copy(tempFile, out.Body); err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return tempFile.Name(), nil
+}
+
+// maxCopyObjectSize is the largest object CopyObject accepts; bigger ones
+// are copied a part at a time.
+const maxCopyObjectSize = 5 << 30 // 5GB
+
+// copyPartSize is the part size for copies too big for CopyObject. S3
+// allows 10,000 parts, so this covers its 5TB object limit.
+const copyPartSize = 512 << 20 // 512MB
+
+// copyObject duplicates srcKey as dstKey inside the bucket, without the
+// data passing through the server.
+func (t *storageTarget) copyObject(ctx context.Context, srcKey, dstKey string) error {
+	head, err := t.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &t.bucket,
+		Key:    &srcKey,
+	})
+	if err != nil {
+		return err
+	}
+	if size := aws.ToInt64(head.ContentLength); size > maxCopyObjectSize {
+		return t.copyInParts(ctx, srcKey, size, &s3.CreateMultipartUploadInput{
+			Key:                &dstKey,
+			ContentType:        head.ContentType,
+			ContentDisposition: head.ContentDisposition,
+			Metadata:           head.Metadata,
+		})
+	}
+
+	source := t.bucket + "/" + url.PathEscape(srcKey)
+	_, err = t.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               &t.bucket,
+		Key:                  &dstKey,
+		CopySource:           &source,
+		ServerSideEncryption: t.sse.mode,
+		SSEKMSKeyId:          t.sse.kmsKeyID,
+	})
+	return err
+}
+
+// copyInParts copies the first size bytes of srcKey with UploadPartCopy,
+// for objects too big for CopyObject. create names the destination and
+// the headers it gets.
+func (t *storageTarget) copyInParts(ctx context.Context, srcKey string, size int64, create *s3.CreateMultipartUploadInput) error {
+	create.Bucket = &t.bucket
+	create.ServerSideEncryption = t.sse.mode
+	create.SSEKMSKeyId = t.sse.kmsKeyID
+	created, err := t.client.CreateMultipartUpload(ctx, create)
+	if err != nil {
+		return err
+	}
+	key, uploadID := aws.ToString(create.Key), aws.ToString(created.UploadId)
+	abort := func(err error) error {
+		t.abortMultipart(context.Background(), key, uploadID)
+		return err
+	}
+
+	source := t.bucket + "/" + url.PathEscape(srcKey)
+	var parts []types.CompletedPart
+	for start, partNumber := int64(0), int32(1); start < size; start, partNumber = start+copyPartSize, partNumber+1 {
+		end := min(start+copyPartSize, size) - 1
+		part, err := t.client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &t.bucket,
+			Key:             &key,
+			CopySource:      &source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			PartNumber:      aws.Int32(partNumber),
+			UploadId:        &uploadID,
+		})
+		if err != nil {
+			return abort(err)
+		}
+		if part.CopyPartResult == nil {
+			return abort(fmt.Errorf("no result for part %d of copy to %s", partNumber, key))
+		}
+		parts = append(parts, types.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int32(partNumber)})
+	}
+	if err := t.completeMultipart(ctx, key, uploadID, parts); err != nil {
+		return abort(err)
+	}
+	return nil
+}
+
+// contentDisposition is how browsers should treat a video file: played in
+// place, or saved.
+func contentDisposition(downloadOnly bool) string {
+	if downloadOnly {
+		return "attachment"
+	}
+	return "inline"
+}
+
+// setContentDisposition rewrites an object's Content-Disposition by copying
+// it onto itself, keeping its Content-Type. CloudFront keeps serving the
+// old header until its cached copy expires.
+func (t *storageTarget) setContentDisposition(ctx context.Context, key, disposition string) error {
+	head, err := t.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &t.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+	if size := aws.ToInt64(head.ContentLength); size > maxCopyObjectSize {
+		return t.copyInParts(ctx, key, size, &s3.CreateMultipartUploadInput{
+			Key:                &key,
+			ContentType:        head.ContentType,
+			ContentDisposition: &disposition,
+			Metadata:           head.Metadata,
+		})
+	}
+
+	source := t.bucket + "/" + url.PathEscape(key)
+	_, err = t.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             &t.bucket,
+		Key:                &key,
+		CopySource:         &source,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        head.ContentType,
+		ContentDisposition: &disposition,
+		Metadata:           head.Metadata,
+		// A copy doesn't keep the source's encryption unless asked again
+		ServerSideEncryption: t.sse.mode,
+		SSEKMSKeyId:          t.sse.kmsKeyID,
+	})
+	return err
+}
+
+func (t *storageTarget) deleteObject(ctx context.Context, key string) error {
+	_, err := t.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &t.bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+// assetClass is a kind of stored asset that can be routed to its own bucket.
+type assetClass string
+
+const (
+	assetThumbnail assetClass = "thumbnail"
+	assetPreview   assetClass = "preview"
+	assetDASH      assetClass = "dash"
+)
+
+// parseAssetTargets reads ASSET_BUCKETS config: semicolon-separated
+// class=region:bucket:distribution entries, e.g.
+// "thumbnail=us-east-1:cheap-bucket:dxxx.cloudfront.net".
+func parseAssetTargets(spec string) (map[assetClass]*storageTarget, error) {
+	targets := map[assetClass]*storageTarget{}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		class, targetSpec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid asset route %q, want class=region:bucket:distribution", entry)
+		}
+		switch assetClass(class) {
+		case assetThumbnail, assetPreview, assetDASH:
+		default:
+			return nil, fmt.Errorf("unknown asset class %q", class)
+		}
+		parsed, err := parseStorageTargets(targetSpec)
+		if err != nil {
+			return nil, err
+		}
+		if len(parsed) != 1 {
+			return nil, fmt.Errorf("asset class %q needs exactly one bucket", class)
+		}
+		targets[assetClass(class)] = parsed[0]
+	}
+	return targets, nil
+}
+
+// assetTarget returns the bucket configured for a class of asset, or
+// fallback, the bucket of the video it belongs to, when there isn't one.
+func (cfg *apiConfig) assetTarget(class assetClass, fallback *storageTarget) *storageTarget {
+	if target, ok := cfg.assetTargets[class]; ok {
+		return target
+	}
+	return fallback
+}
+
+// storeThumbnail saves a thumbnail image and returns its URL. Thumbnails go
+// to the thumbnail bucket when one is configured, then to the primary video
+// bucket if THUMBNAILS_TO_S3 is on, and the local assets directory otherwise.
+func (cfg *apiConfig) storeThumbnail(ctx context.Context, fileName string, body io.Reader, contentType string) (string, error) {
+	var fallback *storageTarget
+	if cfg.thumbnailsToS3 {
+		fallback = cfg.storageTargets[0]
+	}
+	if target := cfg.assetTarget(assetThumbnail, fallback); target != nil {
+		key := "thumbnails/" + fileName
+		if err := target.putObject(ctx, key, body, contentType); err != nil {
+			return "", err
+		}
+		return target.objectURL(key), nil
+	}
+
+	outFile, err := os.Create(filepath.Join(cfg.assetsRoot, fileName))
+	if err != nil {
+		return "", err
+	}
+	defer outFile.Close()
+	if _, err := io.Copy(outFile, body); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, fileName), nil
+}
+
+// locateObject maps a stored CloudFront URL back to the target holding the
+// object and its key. It reports false for URLs that aren't ours.
+func (cfg *apiConfig) locateObject(rawURL string) (*storageTarget, string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", false
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" {
+		return nil, "", false
+	}
+	for _, target := range cfg.storageTargets {
+		if u.Host == target.distribution {
+			return target, key, true
+		}
+	}
+	for _, target := range cfg.assetTargets {
+		if u.Host == target.distribution {
+			return target, key, true
+		}
+	}
+	return nil, "", false
+}
+
+// uploadTarget picks the bucket for a new upload: the region requested in
+// the X-Upload-Region header when we have a bucket there, otherwise the
+// next bucket in round-robin order.
+func (cfg *apiConfig) uploadTarget(r *http.Request) *storageTarget {
+	if region := r.Header.Get("X-Upload-Region"); region != "" {
+		for _, target := range cfg.storageTargets {
+			if target.region == region {
+				return target
+			}
+		}
+	}
+	n := cfg.uploadCounter.Add(1) - 1
+	return cfg.storageTargets[n%uint64(len(cfg.storageTargets))]
+}
+
+// parseStorageTargets reads S3_BUCKETS-style config: comma-separated
+// region:bucket:distribution entries.
+func parseStorageTargets(spec string) ([]*storageTarget, error) {
+	targets := []*storageTarget{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid bucket entry %q, want region:bucket:distribution", entry)
+		}
+		targets = append(targets, &storageTarget{
+			region:       parts[0],
+			bucket:       parts[1],
+			distribution: parts[2],
+		})
+	}
+	return targets, nil
+}
+
+// randomObjectName returns a URL-safe random name for a new object.
+func randomObjectName() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// listObjects returns the keys of every object under prefix.
+func (t *storageTarget) listObjects(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var token *string
+	for {
+		objects, next, err := t.listObjectsPage(ctx, prefix, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			if obj.Key != nil {
+				keys = append(keys, *obj.Key)
+			}
+		}
+		if next == nil {
+			return keys, nil
+		}
+		token = next
+	}
+}
+
+// listObjectsPage returns one page of objects under prefix, starting from a
+// continuation token, and the token for the next page, nil after the last.
+func (t *storageTarget) listObjectsPage(ctx context.Context, prefix string, token *string) ([]types.Object, *string, error) {
+	out, err := t.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:            &t.bucket,
+		Prefix:            &prefix,
+		ContinuationToken: token,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if out.IsTruncated == nil || !*out.IsTruncated {
+		return out.Contents, nil, nil
+	}
+	return out.Contents, out.NextContinuationToken, nil
+}
+
+// deleteObjects removes keys in batches of up to 1000, the S3 limit, and
+// returns the keys that couldn't be deleted.
+func (t *storageTarget) deleteObjects(ctx context.Context, keys []string) ([]string, error) {
+	var failed []string
+	for start := 0; start < len(keys); start += 1000 {
+		batch := keys[start:min(start+1000, len(keys))]
+		ids := make([]types.ObjectIdentifier, 0, len(batch))
+		for _, key := range batch {
+			ids = append(ids, types.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := t.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &t.bucket,
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return failed, err
+		}
+		for _, e := range out.Errors {
+			if e.Key != nil {
+				failed = append(failed, *e.Key)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// targetByBucket finds a configured bucket, for uploads or assets, by name.
+func (cfg *apiConfig) targetByBucket(bucket string) (*storageTarget, bool) {
+	for _, t := range cfg.storageTargets {
+		if t.bucket == bucket {
+			return t, true
+		}
+	}
+	for _, t := range cfg.assetTargets {
+		if t.bucket == bucket {
+			return t, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// streamLimiter caps how many proxied streams each user may have open at
+// once, so one user can't take all of the server's bandwidth.
+type streamLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	active map[uuid.UUID]int
+}
+
+// newStreamLimiter returns a limiter allowing limit streams per user; 0
+// means unlimited.
+func newStreamLimiter(limit int) *streamLimiter {
+	return &streamLimiter{limit: limit, active: map[uuid.UUID]int{}}
+}
+
+// acquire takes a stream slot for userID, reporting false if they're at
+// the limit. Every successful acquire must be paired with a release.
+func (l *streamLimiter) acquire(userID uuid.UUID) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[userID] >= l.limit {
+		return false
+	}
+	l.active[userID]++
+	return true
+}
+
+func (l *streamLimiter) release(userID uuid.UUID) {
+	if l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[userID]--
+	if l.active[userID] <= 0 {
+		delete(l.active, userID)
+	}
+}
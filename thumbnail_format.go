@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// thumbnailFormat is an image format thumbnails can be normalised to, so
+// every stored thumbnail has the same extension and Content-Type.
+type thumbnailFormat struct {
+	name      string
+	ext       string
+	mediaType string
+	// encoderArgs are the ffmpeg output options for a quality from 1 to 100
+	encoderArgs func(quality int) []string
+}
+
+var thumbnailFormats = map[string]thumbnailFormat{
+	"jpeg": {
+		name:      "jpeg",
+		ext:       ".jpg",
+		mediaType: "image/jpeg",
+		encoderArgs: func(quality int) []string {
+			// mjpeg's -q:v runs from 2 (best) to 31 (worst)
+			q := 31 - (quality-1)*29/99
+			return []string{"-c:v", "mjpeg", "-q:v", strconv.Itoa(q), "-f", "image2pipe"}
+		},
+	},
+	"png": {
+		name:      "png",
+		ext:       ".png",
+		mediaType: "image/png",
+		encoderArgs: func(int) []string {
+			// Lossless, so quality doesn't apply
+			return []string{"-c:v", "png", "-f", "image2pipe"}
+		},
+	},
+	"webp": {
+		name:      "webp",
+		ext:       ".webp",
+		mediaType: "image/webp",
+		encoderArgs: func(quality int) []string {
+			return []string{"-c:v", "libwebp", "-quality", strconv.Itoa(quality), "-f", "webp"}
+		},
+	},
+}
+
+// parseThumbnailFormat looks up THUMBNAIL_FORMAT. Empty means thumbnails
+// are stored in whatever format they were uploaded in.
+func parseThumbnailFormat(raw string) (*thumbnailFormat, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	format, ok := thumbnailFormats[raw]
+	if !ok {
+		return nil, fmt.Errorf("unknown thumbnail format %q (want jpeg, png or webp)", raw)
+	}
+	return &format, nil
+}
+
+// convert re-encodes the image at srcPath into this format.
+func (f thumbnailFormat) convert(ctx context.Context, srcPath string, quality int, stall time.Duration) ([]byte, error) {
+	args := []string{"-i", srcPath, "-frames:v", "1"}
+	args = append(args, f.encoderArgs(quality)...)
+	args = append(args, "-y", "pipe:3")
+
+	var out bytes.Buffer
+	if err := runFFmpegTo(ctx, stall, &out, args...); err != nil {
+		return nil, fmt.Errorf("ffmpeg thumbnail conversion failed: %w", err)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg wrote no %s image", f.name)
+	}
+	return out.Bytes(), nil
+}
+
+// convertThumbnailData is convert for an image held in memory.
+func (cfg *apiConfig) convertThumbnailData(ctx context.Context, data []byte) ([]byte, error) {
+	tmp, err := os.CreateTemp("", "tubely-thumbnail-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	return cfg.thumbnailFormat.convert(ctx, tmp.Name(), cfg.thumbnailQuality, cfg.ffmpegStallTimeout)
+}
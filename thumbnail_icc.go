@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	jpegICCIdentifier = []byte("ICC_PROFILE\x00")
+	pngSignature      = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// stripICCProfile removes embedded ICC colour profiles from a JPEG or PNG
+// so browsers render the pixels as sRGB, as they do for untagged images.
+// The image data itself is copied untouched, so this is lossless, but a
+// wide-gamut image will look slightly less saturated than a true
+// conversion would. Images without a profile come back unchanged.
+func stripICCProfile(data []byte, mediaType string) ([]byte, error) {
+	switch mediaType {
+	case "image/jpeg":
+		return stripJPEGICC(data)
+	case "image/png":
+		return stripPNGICC(data)
+	}
+	return data, nil
+}
+
+// stripJPEGICC drops the APP2 segments that carry an ICC profile.
+func stripJPEGICC(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	found := false
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errors.New("malformed JPEG segment")
+		}
+		marker := data[i+1]
+		// Fill bytes may pad the gap between segments
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// Start of scan: the rest is entropy-coded image data
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		if marker == 0xE2 && bytes.HasPrefix(data[i+4:end], jpegICCIdentifier) {
+			found = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !found {
+		return data, nil
+	}
+	return append(out, data[i:]...), nil
+}
+
+// stripPNGICC drops iCCP chunks.
+func stripPNGICC(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	found := false
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		// length, type, data and CRC
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		if string(data[i+4:i+8]) == "iCCP" {
+			found = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	if !found {
+		return data, nil
+	}
+	return out, nil
+}
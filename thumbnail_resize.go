@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// downscaleThumbnail shrinks a PNG or JPEG whose longer edge is over maxEdge
+// to fit, keeping its aspect ratio and format; JPEGs are re-encoded at
+// quality. An image already within bounds, or any image when maxEdge is 0,
+// comes back unchanged so it isn't needlessly recompressed.
+func downscaleThumbnail(data []byte, mediaType string, maxEdge, quality int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode image: %w", err)
+	}
+	if maxEdge <= 0 || max(config.Width, config.Height) <= maxEdge {
+		return data, nil
+	}
+
+	img, err := decodeThumbnail(data)
+	if err != nil {
+		return nil, err
+	}
+	width, height := maxEdge, maxEdge
+	if config.Width >= config.Height {
+		height = max(1, int(math.Round(float64(config.Height)*float64(maxEdge)/float64(config.Width))))
+	} else {
+		width = max(1, int(math.Round(float64(config.Width)*float64(maxEdge)/float64(config.Height))))
+	}
+	resized := resizeBox(img, width, height)
+
+	var buf bytes.Buffer
+	switch mediaType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: quality})
+	case "image/png":
+		err = png.Encode(&buf, resized)
+	default:
+		return nil, fmt.Errorf("can't resize %s images", mediaType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't encode resized image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeBox scales img to width x height by averaging the source pixels
+// each output pixel covers. That's only suitable for shrinking, which is
+// all thumbnails need, and needs nothing beyond the standard library.
+func resizeBox(img image.Image, width, height int) *image.NRGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*srcH/height
+		y1 := max(bounds.Min.Y+(y+1)*srcH/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcW/width
+			x1 := max(bounds.Min.X+(x+1)*srcW/width, x0+1)
+
+			// Sum premultiplied colour so transparent pixels don't bleed
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r * 0xff / a),
+				G: uint8(g * 0xff / a),
+				B: uint8(b * 0xff / a),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
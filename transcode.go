@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTranscodeProfile = "web"
+
+// outputContainer is a file format transcodes can write.
+type outputContainer struct {
+	format      string // ffmpeg muxer
+	ext         string
+	contentType string
+	// videoCodecs, when set, are the only encoders the format can hold
+	videoCodecs []string
+	// audioCodec re-encodes the audio for formats that can't hold what
+	// uploads usually have; empty copies it as-is
+	audioCodec string
+}
+
+const defaultContainer = "mp4"
+
+var outputContainers = map[string]outputContainer{
+	"mp4": {format: "mp4", ext: ".mp4", contentType: "video/mp4"},
+	"mkv": {format: "matroska", ext: ".mkv", contentType: "video/x-matroska"},
+	"webm": {
+		format:      "webm",
+		ext:         ".webm",
+		contentType: "video/webm",
+		videoCodecs: []string{"libvpx", "libvpx-vp9", "libaom-av1", "libsvtav1"},
+		audioCodec:  "libopus",
+	},
+}
+
+// transcodeProfile is a named set of encoder settings an upload can ask for.
+type transcodeProfile struct {
+	Codec     string `json:"codec"`
+	CRF       int    `json:"crf"` // 0 leaves the encoder's default
+	Preset    string `json:"preset"`
+	Scale     string `json:"scale"` // ffmpeg scale size, e.g. "-2:720"
+	FastStart bool   `json:"faststart"`
+	// Fragmented writes fragmented mp4, which can be streamed while encoding
+	Fragmented bool `json:"fragmented"`
+	// Container is the output format: mp4 (the default), mkv or webm
+	Container string `json:"container"`
+
+	// remuxFirst skips re-encoding whenever the streams can be copied as-is
+	remuxFirst bool
+	// frameRate forces constant frame rate output at this rate. It's set
+	// per upload for variable frame rate sources.
+	frameRate string
+	// convert is set per upload for sources that aren't mp4, whose audio
+	// is re-encoded rather than copied
+	convert bool
+}
+
+// container is the format the profile's output is written in.
+func (p transcodeProfile) container() outputContainer {
+	if c, ok := outputContainers[p.Container]; ok {
+		return c
+	}
+	return outputContainers[defaultContainer]
+}
+
+var scalePattern = regexp.MustCompile(`^-?\d+:-?\d+$`)
+
+// builtinTranscodeProfiles holds the profiles available without any
+// configuration. "web" is the original remux-or-re-encode fast-start pass.
+func builtinTranscodeProfiles() map[string]transcodeProfile {
+	return map[string]transcodeProfile{
+		defaultTranscodeProfile: {
+			Codec:      "libx264",
+			CRF:        18,
+			Preset:     "veryfast",
+			FastStart:  true,
+			remuxFirst: true,
+		},
+	}
+}
+
+// parseTranscodeProfiles reads a JSON object of profile name to settings
+// and merges it over the built-in profiles.
+func parseTranscodeProfiles(spec string) (map[string]transcodeProfile, error) {
+	profiles := builtinTranscodeProfiles()
+	if spec == "" {
+		return profiles, nil
+	}
+
+	var configured map[string]transcodeProfile
+	if err := json.Unmarshal([]byte(spec), &configured); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	for name, profile := range configured {
+		if name == "" {
+			return nil, fmt.Errorf("profile names can't be empty")
+		}
+		if profile.Codec == "" {
+			return nil, fmt.Errorf("profile %q: codec is required", name)
+		}
+		if profile.CRF < 0 || profile.CRF > 63 {
+			return nil, fmt.Errorf("profile %q: crf must be between 0 and 63", name)
+		}
+		if profile.FastStart && profile.Fragmented {
+			return nil, fmt.Errorf("profile %q: faststart and fragmented can't be combined", name)
+		}
+		if profile.Container == "" {
+			profile.Container = defaultContainer
+		}
+		container, ok := outputContainers[profile.Container]
+		if !ok {
+			return nil, fmt.Errorf("profile %q: container must be mp4, mkv or webm", name)
+		}
+		if profile.Container != "mp4" && (profile.FastStart || profile.Fragmented) {
+			return nil, fmt.Errorf("profile %q: faststart and fragmented only apply to mp4", name)
+		}
+		if len(container.videoCodecs) > 0 && !slices.Contains(container.videoCodecs, profile.Codec) {
+			return nil, fmt.Errorf("profile %q: %s can't hold %s video; use one of %s",
+				name, profile.Container, profile.Codec, strings.Join(container.videoCodecs, ", "))
+		}
+		if profile.Scale != "" && !scalePattern.MatchString(profile.Scale) {
+			return nil, fmt.Errorf("profile %q: scale must look like \"1280:-2\"", name)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// transcodeWithProfile processes a local video according to profile,
+// returning the path of the output file. maxGrowth only applies to
+// remux-first profiles; see processVideoForFastStart.
+func transcodeWithProfile(ctx context.Context, filePath string, profile transcodeProfile, stall time.Duration, maxGrowth float64) (string, error) {
+	if profile.remuxFirst {
+		return processVideoForFastStart(ctx, filePath, stall, maxGrowth, profile.frameRate, profile.convert)
+	}
+
+	srcInfo, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if err := checkDiskFree(filepath.Dir(filePath), srcInfo.Size()); err != nil {
+		return "", err
+	}
+
+	outputPath := filePath + ".transcoded" + profile.container().ext
+	args := transcodeArgs(filePath, profile, outputPath)
+	err = runFFmpeg(ctx, stall, args...)
+	if err == nil {
+		err = checkOutput(outputPath)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg transcode failed: %w", err)
+	}
+	return outputPath, nil
+}
+
+// transcodeArgs are the ffmpeg arguments that encode filePath with profile
+// into output.
+func transcodeArgs(filePath string, profile transcodeProfile, output string) []string {
+	filter := "setsar=1"
+	if profile.Scale != "" {
+		filter = "scale=" + profile.Scale + "," + filter
+	}
+	args := []string{
+		"-i", filePath,
+		"-map", "0:v",
+		"-map", "0:a?",
+		"-vf", filter,
+		"-c:v", profile.Codec,
+	}
+	if profile.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(profile.CRF))
+	}
+	if profile.Preset != "" {
+		args = append(args, "-preset", profile.Preset)
+	}
+	if profile.frameRate != "" {
+		args = append(args, "-vsync", "cfr", "-r", profile.frameRate)
+	}
+	container := profile.container()
+	if container.audioCodec != "" {
+		args = append(args, "-c:a", container.audioCodec)
+	} else if profile.convert {
+		args = append(args, "-c:a", "aac")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+	switch {
+	case profile.FastStart:
+		args = append(args, "-movflags", "faststart")
+	case profile.Fragmented:
+		args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+	}
+	return append(args, "-f", container.format, "-y", output)
+}
+
+// streamTranscode encodes a local video as fragmented mp4 straight into an
+// object, without writing the output to disk, and returns the object's size.
+// A faststart mp4 can't be produced this way: its index is written last
+// and moved to the front, which needs a seekable output.
+func streamTranscode(ctx context.Context, filePath string, profile transcodeProfile, stall time.Duration, target *storageTarget, key, contentType, disposition string) (int64, error) {
+	type result struct {
+		size int64
+		err  error
+	}
+	pr, pw := io.Pipe()
+	uploaded := make(chan result, 1)
+	go func() {
+		size, err := target.uploadStream(ctx, key, pr, contentType, disposition)
+		// Unblock ffmpeg's writes if the upload gave up early
+		pr.CloseWithError(err)
+		uploaded <- result{size, err}
+	}()
+
+	err := runFFmpegTo(ctx, stall, pw, transcodeArgs(filePath, profile, "pipe:3")...)
+	if err != nil {
+		pw.CloseWithError(err)
+	} else {
+		pw.Close()
+	}
+	res := <-uploaded
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg transcode failed: %w", err)
+	}
+	return res.size, res.err
+}
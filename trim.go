@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimestamp reads a position in a video given as seconds ("75.5") or
+// as "MM:SS" / "HH:MM:SS" with optional fractional seconds.
+func parseTimestamp(raw string) (float64, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", raw)
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", raw)
+		}
+		// Only the final field may carry a fraction or exceed 59
+		if i < len(parts)-1 && value != float64(int(value)) {
+			return 0, fmt.Errorf("invalid timestamp %q", raw)
+		}
+		if i > 0 && value >= 60 {
+			return 0, fmt.Errorf("invalid timestamp %q", raw)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// trimRange is the part of an upload to keep. A zero end means "to the end".
+type trimRange struct {
+	start float64
+	end   float64
+}
+
+// parseTrimRange reads the optional start and end form values, returning
+// nil when neither is set.
+func parseTrimRange(startRaw, endRaw string) (*trimRange, error) {
+	if startRaw == "" && endRaw == "" {
+		return nil, nil
+	}
+
+	var trim trimRange
+	var err error
+	if startRaw != "" {
+		if trim.start, err = parseTimestamp(startRaw); err != nil {
+			return nil, fieldError{"start", err.Error()}
+		}
+	}
+	if endRaw != "" {
+		if trim.end, err = parseTimestamp(endRaw); err != nil {
+			return nil, fieldError{"end", err.Error()}
+		}
+		if trim.end <= trim.start {
+			return nil, fieldError{"end", "must be after start"}
+		}
+	}
+	return &trim, nil
+}
+
+// validate checks the range against the source's duration and fills in an
+// open end.
+func (t *trimRange) validate(duration float64) error {
+	if t.start >= duration {
+		return fieldError{"start", fmt.Sprintf("is past the end of the %.3fs video", duration)}
+	}
+	if t.end == 0 {
+		t.end = duration
+	}
+	if t.end > duration {
+		return fieldError{"end", fmt.Sprintf("is past the end of the %.3fs video", duration)}
+	}
+	return nil
+}
+
+// trimVideo cuts the range out of a local video, returning the path of the
+// new file. It re-encodes with an accurate seek so the clip starts on the
+// requested frame rather than the nearest keyframe.
+func trimVideo(ctx context.Context, filePath string, trim trimRange, stall time.Duration) (string, error) {
+	outputPath := filePath + ".trimmed.mp4"
+	args := seekModeAccurate.inputArgs(filePath, trim.start)
+	args = append(args,
+		"-t", strconv.FormatFloat(trim.end-trim.start, 'f', 3, 64),
+		"-map", "0:v",
+		"-map", "0:a?",
+		"-c:v", "libx264", "-crf", "18", "-preset", "veryfast",
+		"-c:a", "aac",
+		"-y", outputPath,
+	)
+
+	if err := runFFmpeg(ctx, stall, args...); err != nil {
+		return "", fmt.Errorf("ffmpeg trim failed: %w", err)
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// maxUploadFormValueBytes bounds the fields of a video upload form other
+// than the video itself.
+const maxUploadFormValueBytes = 10 << 20
+
+// errSaveUpload marks a failure writing an upload to disk, as opposed to the
+// client's body failing.
+var errSaveUpload = errors.New("couldn't save upload")
+
+// uploadedVideo is the video part of an upload form, written to a temp file
+// the caller removes.
+type uploadedVideo struct {
+	path string
+	// filename is as sent, or made up from the media type when the part had
+	// none, so the stored object's key still gets an extension
+	filename    string
+	contentType string
+	size        int64
+}
+
+// readUploadForm reads a video upload's multipart form part by part,
+// leaving its other fields in r.Form for FormValue. The video part is
+// written straight to a temp file, once, and is kept as a file with its
+// Content-Type even when the client left out its filename, which the
+// standard parser would turn into a plain field. video is nil if the form
+// had no video part.
+func readUploadForm(r *http.Request) (video *uploadedVideo, err error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil && video != nil {
+			os.Remove(video.path)
+			video = nil
+		}
+	}()
+
+	values := url.Values{}
+	valueBytes := int64(0)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return video, err
+		}
+		name := part.FormName()
+		switch {
+		case name == "":
+			part.Close()
+		case name == "video":
+			if video != nil {
+				part.Close()
+				return video, errors.New("more than one video part")
+			}
+			video, err = saveVideoPart(part.FileName(), part.Header.Get("Content-Type"), part)
+			part.Close()
+			if err != nil {
+				return video, err
+			}
+		default:
+			data, err := io.ReadAll(io.LimitReader(part, maxUploadFormValueBytes-valueBytes+1))
+			part.Close()
+			if err != nil {
+				return video, err
+			}
+			valueBytes += int64(len(data))
+			if valueBytes > maxUploadFormValueBytes {
+				return video, errors.New("form fields are too large")
+			}
+			values.Add(name, string(data))
+		}
+	}
+
+	// Like ParseMultipartForm: body fields first, then the query string's
+	r.PostForm = values
+	r.Form = url.Values{}
+	for name, vs := range values {
+		r.Form[name] = append(r.Form[name], vs...)
+	}
+	for name, vs := range r.URL.Query() {
+		r.Form[name] = append(r.Form[name], vs...)
+	}
+	return video, nil
+}
+
+// saveVideoPart writes a video part to a temp file named with the upload's
+// extension.
+//
+// Even a fast-start MP4 sent with skip_processing is spooled rather than
+// piped into PutObject: ingest probes the file before storing it (content,
+// resolution and audio checks, checkMP4) and reads it again afterwards for
+// the aspect ratio that picks the object key, the duration, tech info, the
+// preview and DASH. Neither the probes nor a rejected upload can wait until
+// the object is already in the bucket, and form fields such as
+// skip_processing may arrive after the video part. The one write here is
+// the whole local cost; nothing copies the file again.
+func saveVideoPart(filename, contentType string, body io.Reader) (*uploadedVideo, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if filename == "" {
+		filename = "video" + mediaTypeExtensions[mediaType]
+	}
+	tempFile, err := os.CreateTemp("", "tubely-upload-*"+uploadExtension(filename, mediaType))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSaveUpload, err)
+	}
+	defer tempFile.Close()
+
+	video := &uploadedVideo{path: tempFile.Name(), filename: filename, contentType: contentType}
+	video.size, err = io.Copy(fileWriter{tempFile}, body)
+	uploadBytesSpooled.Add(video.size)
+	if err != nil {
+		os.Remove(video.path)
+		return nil, err
+	}
+	return video, nil
+}
+
+// fileWriter tags write errors with errSaveUpload. It deliberately hides
+// os.File's ReadFrom so io.Copy goes through Write.
+type fileWriter struct {
+	f *os.File
+}
+
+func (w fileWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", errSaveUpload, err)
+	}
+	return n, err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newUploadRequest builds a multipart upload whose video part has the given
+// Content-Disposition, so tests can leave out the filename.
+func newUploadRequest(t *testing.T, disposition, contentType string, data []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", disposition)
+	header.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/x?profile=from-query&trim=1", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestReadUploadFormVideoWithoutFilename(t *testing.T) {
+	data := []byte("not really an mp4, but the bytes must survive")
+	req := newUploadRequest(t, `form-data; name="video"`, "video/mp4", data, map[string]string{"profile": "web"})
+
+	video, err := readUploadForm(req)
+	if err != nil {
+		t.Fatalf("readUploadForm: %v", err)
+	}
+	if video == nil {
+		t.Fatal("video part without a filename was dropped")
+	}
+	defer os.Remove(video.path)
+
+	if video.filename != "video.mp4" {
+		t.Errorf("filename = %q, want a synthetic %q", video.filename, "video.mp4")
+	}
+	if ext := uploadExtension(video.filename, "video/mp4"); ext != ".mp4" {
+		t.Errorf("object key extension = %q, want .mp4", ext)
+	}
+	if filepath.Ext(video.path) != ".mp4" {
+		t.Errorf("temp file %s has no .mp4 extension", video.path)
+	}
+	if video.contentType != "video/mp4" {
+		t.Errorf("content type = %q, want video/mp4", video.contentType)
+	}
+	if video.size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", video.size, len(data))
+	}
+	saved, err := os.ReadFile(video.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Error("saved file differs from the part sent")
+	}
+
+	// Body fields win over the query string, which is still visible
+	if got := req.FormValue("profile"); got != "web" {
+		t.Errorf("profile = %q, want the body's %q", got, "web")
+	}
+	if got := req.FormValue("trim"); got != "1" {
+		t.Errorf("trim = %q, want the query string's %q", got, "1")
+	}
+}
+
+func TestReadUploadFormKeepsFilename(t *testing.T) {
+	req := newUploadRequest(t, `form-data; name="video"; filename="clip.mov"`, "video/quicktime", []byte("moov"), nil)
+
+	video, err := readUploadForm(req)
+	if err != nil {
+		t.Fatalf("readUploadForm: %v", err)
+	}
+	defer os.Remove(video.path)
+	if video.filename != "clip.mov" {
+		t.Errorf("filename = %q, want clip.mov", video.filename)
+	}
+	if ext := uploadExtension(video.filename, "video/quicktime"); ext != ".mov" {
+		t.Errorf("object key extension = %q, want .mov", ext)
+	}
+}
+
+func TestReadUploadFormWithoutVideo(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("source_url", "https://example.com/a.mp4")
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/x", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	video, err := readUploadForm(req)
+	if err != nil {
+		t.Fatalf("readUploadForm: %v", err)
+	}
+	if video != nil {
+		os.Remove(video.path)
+		t.Fatal("got a video part from a form without one")
+	}
+	if got := req.FormValue("source_url"); got != "https://example.com/a.mp4" {
+		t.Errorf("source_url = %q", got)
+	}
+}
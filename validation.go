@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// fieldError is a problem with one field of a submitted form.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e fieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// validationErrors collects every problem with a form so the client can fix
+// them all in one go rather than one request at a time.
+type validationErrors []fieldError
+
+func (v *validationErrors) add(field, format string, args ...interface{}) {
+	*v = append(*v, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v validationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// respondWithValidationErrors writes a 422 listing each invalid field.
+func respondWithValidationErrors(w http.ResponseWriter, errs validationErrors) {
+	type response struct {
+		Error  string       `json:"error"`
+		Errors []fieldError `json:"errors"`
+	}
+	respondWithJSON(w, http.StatusUnprocessableEntity, response{
+		Error:  "Invalid form",
+		Errors: errs,
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strconv"
+)
+
+// vfrTolerance is how far apart, as a fraction, a stream's nominal and
+// average frame rates may be before it counts as variable frame rate.
+const vfrTolerance = 0.01
+
+// constantFrameRate returns the rate a variable frame rate video should be
+// re-encoded at, or "" when its frame rate is already constant. ffprobe
+// reports VFR as an r_frame_rate (the finest rate any frame's timing needs)
+// that differs from avg_frame_rate. target is the configured rate; 0 keeps
+// the source's average.
+func constantFrameRate(ctx context.Context, filePath string, target int) (string, error) {
+	probe, err := probeVideo(ctx, filePath)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range probe.Streams {
+		if s.CodecType != "video" {
+			continue
+		}
+		nominal, avg := parseFrameRate(s.RFrameRate), parseFrameRate(s.AvgFrameRate)
+		if nominal <= 0 || avg <= 0 || math.Abs(nominal-avg)/nominal <= vfrTolerance {
+			return "", nil
+		}
+		if target > 0 {
+			return strconv.Itoa(target), nil
+		}
+		return s.AvgFrameRate, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xaitan80/x-fileserver/internal/database"
+)
+
+// prepareVideo applies response-time URL policy to a video before it's sent
+// to a client. It never modifies what's stored in the database. Every
+// handler that returns videos goes through it (or prepareVideos), so a
+// policy change here applies to all of them.
+func (cfg *apiConfig) prepareVideo(video database.Video) database.Video {
+	if cfg.thumbnailCacheBust && video.ThumbnailURL != nil {
+		busted := withVersionQuery(*video.ThumbnailURL, video.UpdatedAt.Unix())
+		video.ThumbnailURL = &busted
+	}
+	return video
+}
+
+func (cfg *apiConfig) prepareVideos(videos []database.Video) []database.Video {
+	prepared := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		prepared = append(prepared, cfg.prepareVideo(video))
+	}
+	return prepared
+}
+
+// withVersionQuery adds a "v" query parameter so CDNs and browsers treat a
+// replaced asset as a new resource.
+func withVersionQuery(rawURL string, version int64) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	q.Set("v", strconv.FormatInt(version, 10))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// videoFields is the allowlist for ?fields=: every JSON key of a video.
+var videoFields = func() map[string]bool {
+	dat, err := json.Marshal(database.Video{})
+	if err != nil {
+		panic(err)
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(dat, &keys); err != nil {
+		panic(err)
+	}
+	fields := make(map[string]bool, len(keys))
+	for key := range keys {
+		fields[key] = true
+	}
+	return fields
+}()
+
+// respondWithVideo writes a prepared video, or slice of videos, as JSON.
+// ?fields=id,title,... keeps only those keys of each video and ?pretty=true
+// indents the output.
+func (cfg *apiConfig) respondWithVideo(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
+	pretty := false
+	if v := r.URL.Query().Get("pretty"); v != "" {
+		var err error
+		if pretty, err = strconv.ParseBool(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, "pretty must be true or false", err)
+			return
+		}
+	}
+
+	var fields map[string]bool
+	if v := r.URL.Query().Get("fields"); v != "" {
+		fields = map[string]bool{}
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if videoFields[field] {
+				fields[field] = true
+			} else if cfg.strictFieldSelection {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown field %q", field), nil)
+				return
+			}
+		}
+	}
+
+	if !pretty && fields == nil {
+		respondWithJSON(w, code, payload)
+		return
+	}
+
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if fields != nil {
+		if dat, err = selectFields(dat, fields); err != nil {
+			log.Printf("Error selecting fields: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	if pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, dat, "", "  "); err != nil {
+			log.Printf("Error indenting JSON: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		dat = indented.Bytes()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(dat)
+}
+
+// selectFields drops every key not in fields from a JSON object, or from
+// each object of a JSON array. In a page of videos it filters the videos.
+func selectFields(dat []byte, fields map[string]bool) ([]byte, error) {
+	filter := func(object map[string]json.RawMessage) {
+		for key := range object {
+			if !fields[key] {
+				delete(object, key)
+			}
+		}
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(dat), []byte("[")) {
+		var objects []map[string]json.RawMessage
+		if err := json.Unmarshal(dat, &objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			filter(object)
+		}
+		return json.Marshal(objects)
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(dat, &object); err != nil {
+		return nil, err
+	}
+	if videos, ok := object["videos"]; ok && !videoFields["videos"] {
+		filtered, err := selectFields(videos, fields)
+		if err != nil {
+			return nil, err
+		}
+		object["videos"] = filtered
+		return json.Marshal(object)
+	}
+	filter(object)
+	return json.Marshal(object)
+}